@@ -1,21 +1,51 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"html/template"
+	"io"
 	"log"
+	"maps"
+	"math"
+	"math/big"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/http/pprof"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"go.yaml.in/yaml/v2"
 )
 
+// integrationTimestamps are the integration status timestamps INTEGRATION_TIMESTAMPS can select.
+var integrationTimestamps = []string{"last_run", "next_run", "created", "updated"}
+
 type cloudCostStatusResponse struct {
 	Code int `json:"code"`
 	Data []struct {
@@ -26,6 +56,8 @@ type cloudCostStatusResponse struct {
 		Valid            bool   `json:"valid"`
 		LastRun          string `json:"lastRun"`
 		NextRun          string `json:"nextRun"`
+		Created          string `json:"created"`
+		Updated          string `json:"updated"`
 		ConnectionStatus string `json:"connectionStatus"`
 	} `json:"data"`
 }
@@ -34,9 +66,9 @@ type cloudCostTotalsResponse struct {
 	Code int `json:"code"`
 	Data struct {
 		Combined struct {
-			Name              string  `json:"name"`
-			KubernetesPercent float64 `json:"kubernetesPercent"`
-			Cost              float64 `json:"cost"`
+			Name              string    `json:"name"`
+			KubernetesPercent costValue `json:"kubernetesPercent"`
+			Cost              costValue `json:"cost"`
 		} `json:"combined"`
 	} `json:"data"`
 }
@@ -44,9 +76,16 @@ type cloudCostTotalsResponse struct {
 type cloudCostTableResponse struct {
 	Code int `json:"code"`
 	Data []struct {
-		Name              string  `json:"name"`
-		KubernetesPercent float64 `json:"kubernetesPercent"`
-		Cost              float64 `json:"cost"`
+		Name              string    `json:"name"`
+		KubernetesPercent costValue `json:"kubernetesPercent"`
+		Cost              costValue `json:"cost"`
+
+		// Per cost type breakdown, used with TABLE_COST_BREAKDOWN when OpenCost includes it.
+		ListCost         *costValue `json:"listCost"`
+		NetCost          *costValue `json:"netCost"`
+		AmortizedNetCost *costValue `json:"amortizedNetCost"`
+		AmortizedCost    *costValue `json:"amortizedCost"`
+		InvoicedCost     *costValue `json:"invoicedCost"`
 	} `json:"data"`
 }
 
@@ -56,25 +95,445 @@ type cloudCostGraphResponse struct {
 		Start string `json:"start"`
 		End   string `json:"end"`
 		Items []struct {
-			Name  string  `json:"name"`
-			Value float64 `json:"value"`
+			Name  string    `json:"name"`
+			Value costValue `json:"value"`
 		} `json:"items"`
 	} `json:"data"`
 }
 
+// costValue is a cost decoded from OpenCost. It keeps the exact decimal text alongside the float64 so that
+// USE_DECIMAL can detect precision loss and sum a graph day's entries exactly; every other sum is float64. It accepts
+// numbers and numeric strings, so it is also used for other numeric fields such as kubernetesPercent.
+type costValue struct {
+	f   float64
+	raw json.Number
+}
+
+func (c *costValue) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*c = costValue{}
+		return nil
+	}
+	// Some OpenCost builds send numbers as strings (e.g. "1.2e-05"); an empty string counts as no value.
+	if len(b) > 0 && b[0] == '"' {
+		var str string
+		if err := json.Unmarshal(b, &str); err != nil {
+			return err
+		}
+		str = strings.TrimSpace(str)
+		if str == "" {
+			*c = costValue{}
+			return nil
+		}
+		if _, err := strconv.ParseFloat(str, 64); err != nil {
+			return fmt.Errorf("invalid number %q", str)
+		}
+		b = []byte(str)
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return err
+	}
+	*c = costValue{f: f, raw: n}
+	return nil
+}
+
+// rat returns the exact decimal value, or nil if none was decoded.
+func (c costValue) rat() *big.Rat {
+	if c.raw == "" {
+		return nil
+	}
+	r, ok := new(big.Rat).SetString(c.raw.String())
+	if !ok {
+		return nil
+	}
+	return r
+}
+
 type config struct {
 	OpenCostURL     string
+	SocketPath      string // set for unix:// OPENCOST_URL values
 	Window          string
 	CostMetric      string
 	CostMetrics     []string
 	Aggregates      []string
 	RefreshInterval time.Duration
+	RefreshJitter   time.Duration
 	HTTPTimeout     time.Duration
+	ScrapeTimeout   time.Duration
 	ListenAddr      string
+	// EndpointTimeouts holds the per-request timeout for each OpenCost endpoint
+	// ("status", "totals", "table", "graph"); unset entries fall back to HTTPTimeout.
+	EndpointTimeouts map[string]time.Duration
+	// HealthListenAddr optionally serves /healthz and /readyz on a separate address.
+	HealthListenAddr string
+	// CheckOpenCostHealth makes /readyz also require OpenCost to answer /cloudCost/status.
+	CheckOpenCostHealth bool
+
+	// AggregateCostMetrics optionally pins aggregates to specific cost metrics (AGGREGATES="service:netCost,...").
+	// Aggregates without an entry are scraped for every cost metric.
+	AggregateCostMetrics map[string][]string
+
+	// Optional per-aggregate name filters (service/category) and whether filtered-out rows roll up into "__other__".
+	ServiceFilter  nameFilter
+	CategoryFilter nameFilter
+	RollupOther    bool
+
+	// Reconcile, when set (RECONCILE), is passed as the reconcile parameter to the cost endpoints.
+	Reconcile *bool
+
+	// WeekdayBreakdown enables opencost_cloudcost_cost_by_weekday.
+	WeekdayBreakdown bool
+
+	// MaxLabelValueLen, when positive, truncates longer name label values (e.g. "item" names), keeping them
+	// unique with a hash suffix (see truncateLabelValue).
+	MaxLabelValueLen int
+
+	// GraphDeriveFromItem fetches the item graph once per cost metric and rolls it up into the daily series of
+	// the aggregates that are part of item names (see itemFields), instead of one graph call per aggregate.
+	GraphDeriveFromItem bool
+
+	// SummaryTimestamps exports the window cost families (total, aggregate, ...) with the end of the scraped
+	// window as sample timestamp, like the daily series.
+	SummaryTimestamps bool
+
+	// NormalizeCategory lowercases category names before emitting.
+	NormalizeCategory bool
+
+	// ItemSplit exports item rows with each part of their names as a label on opencost_cloudcost_item_cost.
+	ItemSplit bool
+
+	// Rows whose cost is below this value are summed into "__other__" (0 disables).
+	MinCostThreshold float64
+
+	// AggregateTopN keeps only the N most expensive table rows per aggregate and sums the rest into "__other__".
+	// The "" key applies to aggregates without their own entry.
+	AggregateTopN map[string]int
+
+	// DailyWindow is the window used for the daily (graph) series; defaults to Window.
+	DailyWindow string
+
+	// DailyTotalAggregate is the aggregate whose graph provides the daily totals; it is always fetched, with its
+	// daily series, even if it isn't in Aggregates, except for cost metrics an AGGREGATES mapping excludes.
+	DailyTotalAggregate string
+
+	// WindowRelative, when set (WINDOW_MODE=computed), is resolved by the exporter on each scrape into an explicit
+	// "start,end" range sent to OpenCost; Window then holds the relative name and is only used as the label.
+	WindowRelative string
+
+	// IntegrationKeys and StatusProviders, when set, limit integration metrics to these keys/providers.
+	IntegrationKeys []string
+	StatusProviders []string
+
+	// Integrations whose lastRun is older than this are counted as stale.
+	IntegrationStaleAfter time.Duration
+
+	// IntegrationTimestamps lists the status timestamps exported by opencost_cloudcost_integration_run_timestamp,
+	// by their "which" label (see integrationTimestamps).
+	IntegrationTimestamps []string
+
+	// Currency is added as a "currency" label on all cost metrics.
+	Currency string
+
+	// DayLocation is the timezone (DAY_TIMEZONE) of the daily sample timestamps: local midnight of the "day"
+	// label, which is the UTC date OpenCost returned.
+	DayLocation *time.Location
+
+	// DailyReconciledAfter is how long after a day ends its costs are assumed final (reconciled with the cloud
+	// invoice); OpenCost doesn't report it per day.
+	DailyReconciledAfter time.Duration
+
+	// ClampNegativeCosts exports negative aggregate table rows (credits, refunds) as 0; opencost_cloudcost_credits
+	// carries the negative part either way. Totals and daily costs are sums that already net the credits in, so
+	// they are exported as OpenCost returns them.
+	ClampNegativeCosts bool
+
+	// With EmitZeroForMissing, names that were exported for an aggregate in an earlier scrape but are missing from
+	// the current one are exported as 0 until they have been missing for ZeroForMissingTTL. The zeros count
+	// towards MaxSeriesPerScrape, so a limit sized for one scrape's names can reject scrapes once names churn.
+	EmitZeroForMissing bool
+	ZeroForMissingTTL  time.Duration
+
+	// DailyMaxBackfill drops daily samples whose timestamp is older than this (0 keeps all), so Prometheus does
+	// not reject them as out of bounds.
+	DailyMaxBackfill time.Duration
+
+	// ConstLabels are added to every exported metric (CONST_LABELS="team=finops,region=eu").
+	ConstLabels prometheus.Labels
+
+	// SkipInvalidCostMetrics drops cost metrics that OpenCost rejects (HTTP/body 400) instead of failing scrapes.
+	SkipInvalidCostMetrics bool
+
+	// FailOnInitialScrapeError exits at startup if the first scrape fails, instead of serving scrape_success=0.
+	FailOnInitialScrapeError bool
+
+	// ValidateCapabilities probes OpenCost at startup for every cost metric and aggregate and exits if any is rejected.
+	ValidateCapabilities bool
+
+	// OutputFile switches to one-shot mode: scrape once, write metrics there in OpenMetrics format, exit.
+	OutputFile string
+
+	// UseDecimal sums each graph day's entries exactly and warns when converting a cost to float64 loses more than
+	// DecimalWarnThreshold.
+	UseDecimal           bool
+	DecimalWarnThreshold float64
+
+	// CostRatioPairs lists [numerator, denominator] cost metrics for opencost_cloudcost_cost_ratio.
+	CostRatioPairs [][2]string
+
+	// EMAAlpha enables opencost_cloudcost_total_cost_ema with this smoothing factor in (0, 1] (0 disables).
+	EMAAlpha float64
+
+	// After BreakerThreshold consecutive failed scrapes (0 disables), the refresh interval doubles per further
+	// failure up to BreakerMaxInterval, until a scrape succeeds.
+	BreakerThreshold   int
+	BreakerMaxInterval time.Duration
+
+	// With ScrapeAlignToNextRun, the next refresh after a successful scrape is scheduled ScrapeAlignDelay after
+	// the earliest upcoming integration run (nextRun from /cloudCost/status), instead of after RefreshInterval.
+	ScrapeAlignToNextRun bool
+	ScrapeAlignDelay     time.Duration
+
+	// A scrape running longer than ScrapeWatchdogTimeout (0 disables) makes /healthz fail, or exits the
+	// process when ScrapeWatchdogExit is set.
+	ScrapeWatchdogTimeout time.Duration
+	ScrapeWatchdogExit    bool
+
+	// MaxSeriesPerScrape rejects scrapes that would export more cost series than this (0 disables), keeping
+	// the previous data instead.
+	MaxSeriesPerScrape int
+
+	// DisabledMetrics lists metric families (full names) that are not registered or emitted.
+	DisabledMetrics map[string]bool
+
+	// EnablePprof serves net/http/pprof under /debug/pprof/ on ListenAddr.
+	EnablePprof bool
+
+	// EnableBackfill serves POST /backfill on ListenAddr.
+	EnableBackfill bool
+	// BackfillToken, if set, must be sent as "Authorization: Bearer <token>" to /backfill.
+	BackfillToken string
+
+	// EnableDebugLastScrape serves /debug/lastscrape on ListenAddr.
+	EnableDebugLastScrape bool
+
+	// HTTP transport tuning for connections to OpenCost.
+	MaxIdleConns    int
+	MaxConnsPerHost int
+	IdleConnTimeout time.Duration
+
+	// OTLP push: with OTelMetricsEnabled, gauges are also pushed to OTelEndpoint every OTelExportInterval.
+	OTelMetricsEnabled bool
+	OTelEndpoint       string
+	OTelExportInterval time.Duration
+	// PushOnlyChanged pushes only the data points whose value changed since the last successful push.
+	PushOnlyChanged bool
+
+	// TableCostBreakdown exports the per cost type fields OpenCost may include in table rows.
+	TableCostBreakdown bool
+
+	// StatusOnly scrapes only /cloudCost/status, skipping every cost query.
+	StatusOnly bool
+
+	// MaxResponseBytes caps how much of an OpenCost response body is read.
+	MaxResponseBytes int64
+
+	// RequestsPerSecond limits the rate of OpenCost requests (0 disables the limit); RequestsBurst
+	// is how many may be sent at once before the limit applies.
+	RequestsPerSecond float64
+	RequestsBurst     int
+
+	// DecodeRetries is how many times a request is repeated when its 2xx response body is not valid JSON.
+	DecodeRetries int
+
+	// HTTP2PriorKnowledge speaks HTTP/2 to OpenCost without negotiation (h2c for http:// URLs).
+	HTTP2PriorKnowledge bool
+
+	// LogTLSCertInfo logs the OpenCost server certificate on the first TLS connection and exports its expiry.
+	LogTLSCertInfo bool
+
+	// Settings records every setting mustConfig read, with the value it used and where that came from.
+	Settings map[string]configSetting
+
+	// TLSClientCertDir holds tls.crt and tls.key, presented to OpenCost as client certificate. They are read on
+	// every new connection, so rotated files are picked up without a restart.
+	TLSClientCertDir string
+}
+
+// nameFilter matches names against allow/deny patterns. Patterns are exact names, with "*" matching any run of characters.
+// An empty allow list allows everything; deny always wins over allow.
+type nameFilter struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+func newNameFilter(allow, deny []string) nameFilter {
+	compile := func(patterns []string) []*regexp.Regexp {
+		out := make([]*regexp.Regexp, 0, len(patterns))
+		for _, p := range patterns {
+			expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(p), `\*`, ".*") + "$"
+			out = append(out, regexp.MustCompile(expr))
+		}
+		return out
+	}
+	return nameFilter{allow: compile(allow), deny: compile(deny)}
+}
+
+func (f nameFilter) empty() bool {
+	return len(f.allow) == 0 && len(f.deny) == 0
+}
+
+func (f nameFilter) match(name string) bool {
+	for _, re := range f.deny {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, re := range f.allow {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAggregates splits AGGREGATES entries of the form "aggregate" or "aggregate:costMetric" into the
+// de-duplicated aggregate list and the per-aggregate cost metric mapping.
+func parseAggregates(entries []string) ([]string, map[string][]string) {
+	var aggs []string
+	mapping := map[string][]string{}
+	for _, entry := range entries {
+		agg, costMetric, mapped := strings.Cut(entry, ":")
+		agg, costMetric = strings.TrimSpace(agg), strings.TrimSpace(costMetric)
+		if agg == "" || (mapped && costMetric == "") {
+			log.Fatalf("invalid AGGREGATES entry %q", entry)
+		}
+		if !slices.Contains(aggs, agg) {
+			aggs = append(aggs, agg)
+		}
+		if mapped && !slices.Contains(mapping[agg], costMetric) {
+			mapping[agg] = append(mapping[agg], costMetric)
+		}
+	}
+	return aggs, mapping
+}
+
+// scrapesAggregate reports whether agg is scraped for costMetric, i.e. AGGREGATES does not pin it to other
+// cost metrics.
+func (c config) scrapesAggregate(agg, costMetric string) bool {
+	mapped, ok := c.AggregateCostMetrics[agg]
+	return !ok || slices.Contains(mapped, costMetric)
+}
+
+// splitList splits a comma-separated env value, trimming spaces and dropping empty entries.
+func splitList(s string) []string {
+	parts := strings.Split(s, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// resolveRelativeWindow turns a WINDOW_RELATIVE value into a [start, end) range of whole UTC days relative to now:
+// "this-month" (month to date, including today), "last-month", or "last-<N>d-complete" (the N days before today).
+func resolveRelativeWindow(rel string, now time.Time) (time.Time, time.Time, error) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	switch rel {
+	case "this-month":
+		return thisMonth, today.AddDate(0, 0, 1), nil
+	case "last-month":
+		return thisMonth.AddDate(0, -1, 0), thisMonth, nil
+	}
+	if s, ok := strings.CutPrefix(rel, "last-"); ok {
+		if s, ok = strings.CutSuffix(s, "d-complete"); ok {
+			if n, err := strconv.Atoi(s); err == nil && n > 0 {
+				return today.AddDate(0, 0, -n), today, nil
+			}
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("unsupported relative window %q", rel)
+}
+
+// validateWindow checks the explicit "start,end" RFC3339 range form of an OpenCost window and returns its bounds.
+// Other forms (e.g. "14d", "lastweek") are left for OpenCost to interpret and return zero times.
+func validateWindow(w string) (time.Time, time.Time, error) {
+	startS, endS, isRange := strings.Cut(w, ",")
+	if !isRange {
+		return time.Time{}, time.Time{}, nil
+	}
+	start, err := time.Parse(time.RFC3339, strings.TrimSpace(startS))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("window %q: start is not RFC3339: %w", w, err)
+	}
+	end, err := time.Parse(time.RFC3339, strings.TrimSpace(endS))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("window %q: end is not RFC3339: %w", w, err)
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("window %q: start must be before end", w)
+	}
+	return start, end, nil
+}
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// minWatchdogTimeout is the shortest SCRAPE_WATCHDOG_TIMEOUT accepted.
+const minWatchdogTimeout = time.Second
+
+// exporterLabelNames are the labels the exporter sets on its own series, which CONST_LABELS may not reuse.
+var exporterLabelNames = []string{
+	"account_id", "aggregate", "category", "connection_status", "cost_metric", "cost_type", "currency", "day",
+	"denominator", "endpoint", "field", "invoice_entity_id", "key", "name", "numerator", "provider", "provider_id",
+	"service", "source", "weekday", "which", "window",
+}
+
+// getenv reads a setting from the environment. ENV_PREFIX (e.g. "CLOUDCOST_") namespaces every setting, so
+// CLOUDCOST_OPENCOST_URL is read instead of OPENCOST_URL. Settings without a prefixed variable fall back to the
+// unprefixed name.
+func getenv(k string) string {
+	if prefix := os.Getenv("ENV_PREFIX"); prefix != "" {
+		if v, ok := os.LookupEnv(prefix + k); ok {
+			return v
+		}
+	}
+	return os.Getenv(k)
+}
+
+// configSetting is the value of one setting and its source: "file" (a JOBS_FILE job), "env" or "default" (unset).
+type configSetting struct {
+	Value  string `json:"value,omitempty"`
+	Source string `json:"source"`
 }
 
-func mustConfig() config {
-	get := func(k string) string { return os.Getenv(k) }
+// mustConfig reads the config from the environment; settings in overrides (a JOBS_FILE job) take precedence.
+func mustConfig(overrides map[string]string) config {
+	settings := map[string]configSetting{}
+	get := func(k string) string {
+		if v, ok := overrides[k]; ok {
+			settings[k] = configSetting{Value: v, Source: "file"}
+			return v
+		}
+		v := getenv(k)
+		if v == "" {
+			settings[k] = configSetting{Source: "default"}
+		} else {
+			settings[k] = configSetting{Value: v, Source: "env"}
+		}
+		return v
+	}
 
 	cfg := config{
 		OpenCostURL: get("OPENCOST_URL"),
@@ -86,29 +545,68 @@ func mustConfig() config {
 	if cfg.OpenCostURL == "" {
 		log.Fatal("OPENCOST_URL is required")
 	}
-	if cfg.Window == "" {
+	// Status-only mode never queries costs, so WINDOW and COST_METRIC may be left unset.
+	cfg.StatusOnly = get("STATUS_ONLY") == "true"
+	u, err := url.Parse(cfg.OpenCostURL)
+	if err != nil {
+		log.Fatalf("invalid OPENCOST_URL: %v", err)
+	}
+	if u.Scheme == "unix" && u.Path != "" {
+		// unix:///path/to.sock: dial the socket, but keep forming regular HTTP request URLs.
+		cfg.SocketPath = u.Path
+		u = &url.URL{Scheme: "http", Host: "localhost"}
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Fatalf("invalid OPENCOST_URL %q: expected http(s)://host[:port] or unix:///path/to.sock", cfg.OpenCostURL)
+	}
+	// A trailing slash would produce "//cloudCost/..." paths, which some reverse proxies reject.
+	cfg.OpenCostURL = strings.TrimRight(u.String(), "/")
+
+	switch mode := get("WINDOW_MODE"); mode {
+	case "", "named":
+	case "computed":
+		cfg.WindowRelative = get("WINDOW_RELATIVE")
+		if _, _, err := resolveRelativeWindow(cfg.WindowRelative, time.Now()); err != nil {
+			log.Fatalf("invalid WINDOW_RELATIVE: %v", err)
+		}
+		cfg.Window = cfg.WindowRelative
+	default:
+		log.Fatalf("invalid WINDOW_MODE %q (expected named or computed)", mode)
+	}
+	if cfg.Window == "" && !cfg.StatusOnly {
 		log.Fatal("WINDOW is required")
 	}
-	if cfg.CostMetric == "" {
+	cfg.DailyWindow = get("DAILY_WINDOW")
+	if cfg.DailyWindow == "" {
+		cfg.DailyWindow = cfg.Window
+	}
+	if cfg.WindowRelative == "" && cfg.Window != "" {
+		if _, _, err := validateWindow(cfg.Window); err != nil {
+			log.Fatalf("invalid WINDOW: %v", err)
+		}
+	}
+	if _, _, err := validateWindow(cfg.DailyWindow); cfg.DailyWindow != cfg.Window && err != nil {
+		log.Fatalf("invalid DAILY_WINDOW: %v", err)
+	}
+	cfg.DailyTotalAggregate = get("DAILY_TOTAL_AGGREGATE")
+	if cfg.DailyTotalAggregate == "" {
+		cfg.DailyTotalAggregate = "service"
+	}
+	if cfg.CostMetric == "" && !cfg.StatusOnly {
 		log.Fatal("COST_METRIC is required")
 	}
 	if cfg.ListenAddr == "" {
 		cfg.ListenAddr = ":8080"
 	}
+	cfg.HealthListenAddr = get("HEALTH_LISTEN_ADDR")
+	cfg.CheckOpenCostHealth = get("CHECK_OPENCOST_HEALTH") == "true"
 
 	// Optional lists:
 	// - COST_METRICS: comma-separated list of costMetric values to scrape (e.g. "amortizedNetCost,netCost,listCost")
 	// - AGGREGATES: comma-separated list of aggregate properties to scrape (e.g. "service,category,accountID,provider,regionID,availabilityZone")
 	// If not set, default to the existing single COST_METRIC and "service,category".
 	if s := get("COST_METRICS"); s != "" {
-		parts := strings.Split(s, ",")
-		out := make([]string, 0, len(parts))
-		for _, p := range parts {
-			p = strings.TrimSpace(p)
-			if p != "" {
-				out = append(out, p)
-			}
-		}
+		out := splitList(s)
 		if len(out) == 0 {
 			log.Fatal("COST_METRICS is set but empty")
 		}
@@ -118,21 +616,22 @@ func mustConfig() config {
 	}
 
 	if s := get("AGGREGATES"); s != "" {
-		parts := strings.Split(s, ",")
-		out := make([]string, 0, len(parts))
-		for _, p := range parts {
-			p = strings.TrimSpace(p)
-			if p != "" {
-				out = append(out, p)
-			}
-		}
+		out := splitList(s)
 		if len(out) == 0 {
 			log.Fatal("AGGREGATES is set but empty")
 		}
-		cfg.Aggregates = out
+		cfg.Aggregates, cfg.AggregateCostMetrics = parseAggregates(out)
 	} else {
 		cfg.Aggregates = []string{"service", "category"}
 	}
+	// Mapped cost metrics must be scraped even if not listed in COST_METRICS.
+	for _, agg := range cfg.Aggregates {
+		for _, cm := range cfg.AggregateCostMetrics[agg] {
+			if !slices.Contains(cfg.CostMetrics, cm) {
+				cfg.CostMetrics = append(cfg.CostMetrics, cm)
+			}
+		}
+	}
 
 	if s := get("REFRESH_INTERVAL"); s != "" {
 		d, err := time.ParseDuration(s)
@@ -143,6 +642,33 @@ func mustConfig() config {
 	} else {
 		cfg.RefreshInterval = 5 * time.Minute
 	}
+	// Refreshing too often can overwhelm OpenCost on large accounts, so short intervals are clamped to a floor.
+	minRefresh := time.Minute
+	if s := get("MIN_REFRESH_INTERVAL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid MIN_REFRESH_INTERVAL: %q", s)
+		}
+		minRefresh = d
+	}
+	if cfg.RefreshInterval < minRefresh {
+		log.Printf("REFRESH_INTERVAL=%s is below MIN_REFRESH_INTERVAL=%s, using %s", cfg.RefreshInterval, minRefresh, minRefresh)
+		cfg.RefreshInterval = minRefresh
+	}
+
+	// REFRESH_JITTER is either a duration ("30s") or a fraction of REFRESH_INTERVAL ("0.1").
+	if s := get("REFRESH_JITTER"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			cfg.RefreshJitter = d
+		} else if f, err := strconv.ParseFloat(s, 64); err == nil && f >= 0 && f <= 1 {
+			cfg.RefreshJitter = time.Duration(f * float64(cfg.RefreshInterval))
+		} else {
+			log.Fatalf("invalid REFRESH_JITTER: %q (expected a duration or a fraction between 0 and 1)", s)
+		}
+		if cfg.RefreshJitter < 0 || cfg.RefreshJitter > cfg.RefreshInterval {
+			log.Fatalf("invalid REFRESH_JITTER: %q must be between 0 and REFRESH_INTERVAL", s)
+		}
+	}
 
 	if s := get("HTTP_TIMEOUT"); s != "" {
 		d, err := time.ParseDuration(s)
@@ -153,552 +679,3500 @@ func mustConfig() config {
 	} else {
 		cfg.HTTPTimeout = 30 * time.Second
 	}
+	// HTTP_TIMEOUT_STATUS, HTTP_TIMEOUT_TOTALS, HTTP_TIMEOUT_TABLE and HTTP_TIMEOUT_GRAPH override HTTP_TIMEOUT
+	// for one endpoint, e.g. to give slow item-level graph queries more time than status checks.
+	cfg.EndpointTimeouts = map[string]time.Duration{}
+	for _, ep := range []string{"status", "totals", "table", "graph"} {
+		k := "HTTP_TIMEOUT_" + strings.ToUpper(ep)
+		cfg.EndpointTimeouts[ep] = cfg.HTTPTimeout
+		if s := get(k); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				log.Fatalf("invalid %s: %q", k, s)
+			}
+			cfg.EndpointTimeouts[ep] = d
+		}
+	}
 
-	return cfg
-}
-
-type exporter struct {
-	cfg config
-	cli *http.Client
+	// HTTP_TIMEOUT (or its per-endpoint override) bounds each OpenCost request; SCRAPE_TIMEOUT bounds a whole scrape,
+	// which makes many sequential requests.
+	if s := get("SCRAPE_TIMEOUT"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("invalid SCRAPE_TIMEOUT: %v", err)
+		}
+		cfg.ScrapeTimeout = d
+	} else {
+		cfg.ScrapeTimeout = max(cfg.RefreshInterval, 2*cfg.HTTPTimeout)
+	}
 
-	scrapeSuccess      prometheus.Gauge
-	scrapeDuration     prometheus.Gauge
-	cloudIntegrationUp *prometheus.GaugeVec
-	cloudIntegrationTS *prometheus.GaugeVec
-	cloudTotalCost     *prometheus.GaugeVec
-	cloudAggCost       *prometheus.GaugeVec
-	cloudAggK8sPct     *prometheus.GaugeVec
-	cloudServiceCost   *prometheus.GaugeVec
-	cloudServiceK8sPct *prometheus.GaugeVec
-	cloudCategoryCost  *prometheus.GaugeVec
+	// Optional name filters, e.g. SERVICE_DENYLIST="AWS*Support,Tax" or CATEGORY_ALLOWLIST="Compute,Storage".
+	cfg.ServiceFilter = newNameFilter(splitList(get("SERVICE_ALLOWLIST")), splitList(get("SERVICE_DENYLIST")))
+	cfg.NormalizeCategory = get("NORMALIZE_CATEGORY") == "true"
+	// The category filters match normalized names, so they are normalized the same way.
+	categoryAllow, categoryDeny := splitList(get("CATEGORY_ALLOWLIST")), splitList(get("CATEGORY_DENYLIST"))
+	if cfg.NormalizeCategory {
+		for _, list := range [][]string{categoryAllow, categoryDeny} {
+			for i, v := range list {
+				list[i] = strings.ToLower(v)
+			}
+		}
+	}
+	cfg.CategoryFilter = newNameFilter(categoryAllow, categoryDeny)
+	cfg.RollupOther = get("ROLLUP_OTHER") == "true"
+	cfg.ItemSplit = get("ITEM_SPLIT") == "true"
+	cfg.WeekdayBreakdown = get("WEEKDAY_BREAKDOWN") == "true"
+	cfg.SummaryTimestamps = get("SUMMARY_TIMESTAMPS") == "true"
+	cfg.GraphDeriveFromItem = get("GRAPH_DERIVE_FROM_ITEM") == "true"
+	if s := get("MAX_LABEL_VALUE_LEN"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || (n > 0 && n <= labelHashSuffixLen) {
+			log.Fatalf("invalid MAX_LABEL_VALUE_LEN: %q (expected 0 or more than %d)", s, labelHashSuffixLen)
+		}
+		cfg.MaxLabelValueLen = n
+	}
 
-	// Daily metrics need explicit sample timestamps (derived from the day) so time-based alerting (offset) works.
-	daily *dailyCollector
-}
+	if s := get("RECONCILE"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("invalid RECONCILE: %q (expected true or false)", s)
+		}
+		cfg.Reconcile = &b
+	}
 
-func newExporter(cfg config) *exporter {
-	daily := newDailyCollector()
-	e := &exporter{
-		cfg: cfg,
-		cli: &http.Client{Timeout: cfg.HTTPTimeout},
-		scrapeSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "opencost_cloudcost_exporter_scrape_success",
-			Help: "1 if the last scrape from OpenCost succeeded; 0 otherwise.",
-		}),
-		scrapeDuration: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "opencost_cloudcost_exporter_scrape_duration_seconds",
-			Help: "Duration of the last scrape from OpenCost in seconds.",
-		}),
-		cloudIntegrationUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "opencost_cloudcost_integration_up",
-			Help: "1 if the configured Cloud Cost integration is active+valid; 0 otherwise.",
-		}, []string{"key", "provider", "source", "connection_status"}),
-		cloudIntegrationTS: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "opencost_cloudcost_integration_run_timestamp",
-			Help: "Timestamps (unix seconds) for cloud cost integration runs.",
-		}, []string{"key", "provider", "which"}),
-		cloudTotalCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "opencost_cloudcost_total_cost",
-			Help: "Total cloud cost over the configured window.",
-		}, []string{"window", "cost_metric"}),
-		cloudAggCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "opencost_cloudcost_aggregate_cost",
-			Help: "Cloud cost by aggregate property over the configured window.",
-		}, []string{"aggregate", "name", "window", "cost_metric"}),
-		cloudAggK8sPct: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "opencost_cloudcost_aggregate_kubernetes_percent",
-			Help: "KubernetesPercent by aggregate property over the configured window.",
-		}, []string{"aggregate", "name", "window", "cost_metric"}),
-		cloudServiceCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "opencost_cloudcost_service_cost",
-			Help: "Cloud cost by service over the configured window.",
-		}, []string{"service", "window", "cost_metric"}),
-		cloudServiceK8sPct: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "opencost_cloudcost_service_kubernetes_percent",
-			Help: "KubernetesPercent by service over the configured window.",
-		}, []string{"service", "window", "cost_metric"}),
-		cloudCategoryCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "opencost_cloudcost_category_cost",
-			Help: "Cloud cost by category (resource type) over the configured window.",
-		}, []string{"category", "window", "cost_metric"}),
-		daily: daily,
+	if s := get("MIN_COST_THRESHOLD"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v < 0 {
+			log.Fatalf("invalid MIN_COST_THRESHOLD: %q", s)
+		}
+		cfg.MinCostThreshold = v
+	}
+	if s := get("AGGREGATE_TOP_N"); s != "" {
+		cfg.AggregateTopN = map[string]int{}
+		for _, entry := range splitList(s) {
+			agg, nStr, ok := strings.Cut(entry, "=")
+			if !ok {
+				agg, nStr = "", entry
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(nStr))
+			if err != nil || n < 1 {
+				log.Fatalf("invalid AGGREGATE_TOP_N entry %q: expected N or aggregate=N with N > 0", entry)
+			}
+			cfg.AggregateTopN[strings.TrimSpace(agg)] = n
+		}
 	}
 
-	prometheus.MustRegister(e.scrapeSuccess)
-	prometheus.MustRegister(e.scrapeDuration)
-	prometheus.MustRegister(e.cloudIntegrationUp)
-	prometheus.MustRegister(e.cloudIntegrationTS)
-	prometheus.MustRegister(e.cloudTotalCost)
-	prometheus.MustRegister(e.cloudAggCost)
-	prometheus.MustRegister(e.cloudAggK8sPct)
-	prometheus.MustRegister(e.cloudServiceCost)
-	prometheus.MustRegister(e.cloudServiceK8sPct)
-	prometheus.MustRegister(e.cloudCategoryCost)
-	prometheus.MustRegister(e.daily)
+	cfg.IntegrationKeys = splitList(get("INTEGRATION_KEY_FILTER"))
+	cfg.StatusProviders = splitList(get("STATUS_PROVIDER_FILTER"))
 
-	return e
-}
+	cfg.IntegrationTimestamps = []string{"last_run", "next_run"}
+	if s := get("INTEGRATION_TIMESTAMPS"); s != "" {
+		cfg.IntegrationTimestamps = splitList(s)
+		for _, which := range cfg.IntegrationTimestamps {
+			if !slices.Contains(integrationTimestamps, which) {
+				log.Fatalf("invalid INTEGRATION_TIMESTAMPS entry %q: expected one of %s", which, strings.Join(integrationTimestamps, ", "))
+			}
+		}
+	}
 
-func (e *exporter) statusURL() string {
-	return fmt.Sprintf("%s/cloudCost/status", e.cfg.OpenCostURL)
-}
+	if s := get("INTEGRATION_STALE_AFTER"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("invalid INTEGRATION_STALE_AFTER: %v", err)
+		}
+		cfg.IntegrationStaleAfter = d
+	} else {
+		cfg.IntegrationStaleAfter = 48 * time.Hour
+	}
 
-func (e *exporter) totalsURL(costMetric string) string {
-	return fmt.Sprintf("%s/cloudCost/view/totals?window=%s&aggregate=service&accumulate=day&costMetric=%s", e.cfg.OpenCostURL, e.cfg.Window, costMetric)
-}
+	if s := get("CONST_LABELS"); s != "" {
+		cfg.ConstLabels = prometheus.Labels{}
+		for _, kv := range splitList(s) {
+			k, v, ok := strings.Cut(kv, "=")
+			k = strings.TrimSpace(k)
+			if !ok || !labelNameRE.MatchString(k) || strings.HasPrefix(k, "__") {
+				log.Fatalf("invalid CONST_LABELS entry %q: expected name=value with a valid Prometheus label name", kv)
+			}
+			if k == "currency" {
+				log.Fatal("invalid CONST_LABELS: currency is set on cost metrics via CURRENCY")
+			}
+			if slices.Contains(exporterLabelNames, k) {
+				log.Fatalf("invalid CONST_LABELS: %s is a label the exporter sets itself", k)
+			}
+			cfg.ConstLabels[k] = strings.TrimSpace(v)
+		}
+	}
 
-func (e *exporter) tableURL(aggregate, costMetric string) string {
-	// "item" (aka no aggregate param) returns fully-qualified names like:
-	// invoiceEntityID/accountID/provider/providerID/category/service
-	// which lets you break down by resource/providerID.
-	if aggregate == "item" {
-		return fmt.Sprintf("%s/cloudCost/view/table?window=%s&accumulate=day&costMetric=%s&sortBy=cost&sortByOrder=desc&limit=500", e.cfg.OpenCostURL, e.cfg.Window, costMetric)
+	cfg.Currency = get("CURRENCY")
+	if cfg.Currency == "" {
+		cfg.Currency = "USD"
 	}
-	return fmt.Sprintf("%s/cloudCost/view/table?window=%s&aggregate=%s&accumulate=day&costMetric=%s&sortBy=cost&sortByOrder=desc&limit=500", e.cfg.OpenCostURL, e.cfg.Window, aggregate, costMetric)
-}
 
-func (e *exporter) graphURL(aggregate, costMetric string) string {
-	if aggregate == "item" {
-		return fmt.Sprintf("%s/cloudCost/view/graph?window=%s&accumulate=day&costMetric=%s", e.cfg.OpenCostURL, e.cfg.Window, costMetric)
+	cfg.DayLocation = time.UTC
+	if v := get("DAY_TIMEZONE"); v != "" {
+		loc, err := time.LoadLocation(v)
+		if err != nil {
+			log.Fatalf("invalid DAY_TIMEZONE: %v", err)
+		}
+		cfg.DayLocation = loc
 	}
-	return fmt.Sprintf("%s/cloudCost/view/graph?window=%s&aggregate=%s&accumulate=day&costMetric=%s", e.cfg.OpenCostURL, e.cfg.Window, aggregate, costMetric)
-}
 
-func (e *exporter) scrape(ctx context.Context) error {
-	start := time.Now()
-	defer func() {
-		e.scrapeDuration.Set(time.Since(start).Seconds())
-	}()
+	if s := get("DAILY_RECONCILED_AFTER"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid DAILY_RECONCILED_AFTER: %q", s)
+		}
+		cfg.DailyReconciledAfter = d
+	} else {
+		cfg.DailyReconciledAfter = 72 * time.Hour
+	}
+
+	cfg.ClampNegativeCosts = get("CLAMP_NEGATIVE_COSTS") == "true"
+	cfg.EmitZeroForMissing = get("EMIT_ZERO_FOR_MISSING") == "true"
+	if s := get("ZERO_FOR_MISSING_TTL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid ZERO_FOR_MISSING_TTL: %q", s)
+		}
+		cfg.ZeroForMissingTTL = d
+	} else {
+		cfg.ZeroForMissingTTL = 7 * 24 * time.Hour
+	}
+
+	if s := get("DAILY_MAX_BACKFILL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid DAILY_MAX_BACKFILL: %q", s)
+		}
+		cfg.DailyMaxBackfill = d
+	}
+
+	cfg.OutputFile = get("OUTPUT_FILE")
+	cfg.FailOnInitialScrapeError = get("FAIL_ON_INITIAL_SCRAPE_ERROR") == "true"
+	cfg.ValidateCapabilities = get("VALIDATE_CAPABILITIES") == "true"
+	cfg.SkipInvalidCostMetrics = get("SKIP_INVALID_COST_METRICS") == "true"
+	cfg.EnablePprof = get("ENABLE_PPROF") == "true"
+	cfg.EnableBackfill = get("ENABLE_BACKFILL") == "true"
+	if cfg.EnableBackfill && cfg.StatusOnly {
+		log.Fatal("ENABLE_BACKFILL cannot be used with STATUS_ONLY")
+	}
+	cfg.BackfillToken = get("BACKFILL_TOKEN")
+	cfg.EnableDebugLastScrape = get("ENABLE_DEBUG_LASTSCRAPE") == "true"
+
+	// DISABLED_METRICS names are validated against the registered families in newExporter.
+	cfg.DisabledMetrics = map[string]bool{}
+	for _, name := range splitList(get("DISABLED_METRICS")) {
+		cfg.DisabledMetrics[name] = true
+	}
+
+	for _, pair := range splitList(get("COST_RATIO_PAIRS")) {
+		num, den, ok := strings.Cut(pair, "/")
+		num, den = strings.TrimSpace(num), strings.TrimSpace(den)
+		if !ok || !slices.Contains(cfg.CostMetrics, num) || !slices.Contains(cfg.CostMetrics, den) {
+			log.Fatalf("invalid COST_RATIO_PAIRS entry %q: expected numerator/denominator, both in COST_METRICS", pair)
+		}
+		cfg.CostRatioPairs = append(cfg.CostRatioPairs, [2]string{num, den})
+	}
+
+	if s := get("EMA_ALPHA"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v <= 0 || v > 1 {
+			log.Fatalf("invalid EMA_ALPHA: %q (expected a value in (0, 1])", s)
+		}
+		cfg.EMAAlpha = v
+	}
+
+	if s := get("CIRCUIT_BREAKER_THRESHOLD"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid CIRCUIT_BREAKER_THRESHOLD: %q", s)
+		}
+		cfg.BreakerThreshold = n
+	}
+	if s := get("CIRCUIT_BREAKER_MAX_INTERVAL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("invalid CIRCUIT_BREAKER_MAX_INTERVAL: %v", err)
+		}
+		cfg.BreakerMaxInterval = d
+	} else {
+		cfg.BreakerMaxInterval = time.Hour
+	}
+	cfg.BreakerMaxInterval = max(cfg.BreakerMaxInterval, cfg.RefreshInterval)
+
+	cfg.ScrapeAlignToNextRun = get("SCRAPE_ALIGN_TO_NEXT_RUN") == "true"
+	if s := get("SCRAPE_ALIGN_DELAY"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid SCRAPE_ALIGN_DELAY: %q", s)
+		}
+		cfg.ScrapeAlignDelay = d
+	} else {
+		cfg.ScrapeAlignDelay = 5 * time.Minute
+	}
+
+	if s := get("SCRAPE_WATCHDOG_TIMEOUT"); s != "" {
+		// The exit watchdog polls every quarter of the timeout, so it has to be positive; shorter than a second
+		// would flag ordinary scrapes as stuck.
+		d, err := time.ParseDuration(s)
+		if err != nil || d < minWatchdogTimeout {
+			log.Fatalf("invalid SCRAPE_WATCHDOG_TIMEOUT: %q (minimum %s)", s, minWatchdogTimeout)
+		}
+		cfg.ScrapeWatchdogTimeout = d
+	}
+	switch action := get("SCRAPE_WATCHDOG_ACTION"); action {
+	case "", "unhealthy":
+	case "exit":
+		cfg.ScrapeWatchdogExit = true
+	default:
+		log.Fatalf("invalid SCRAPE_WATCHDOG_ACTION %q (expected unhealthy or exit)", action)
+	}
+
+	cfg.UseDecimal = get("USE_DECIMAL") == "true"
+	cfg.DecimalWarnThreshold = 0.01
+	if s := get("DECIMAL_WARN_THRESHOLD"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v < 0 {
+			log.Fatalf("invalid DECIMAL_WARN_THRESHOLD: %q", s)
+		}
+		cfg.DecimalWarnThreshold = v
+	}
+
+	if s := get("MAX_SERIES_PER_SCRAPE"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid MAX_SERIES_PER_SCRAPE: %q", s)
+		}
+		cfg.MaxSeriesPerScrape = n
+	}
+
+	cfg.MaxIdleConns = 100
+	if s := get("MAX_IDLE_CONNS"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid MAX_IDLE_CONNS: %q", s)
+		}
+		cfg.MaxIdleConns = n
+	}
+	cfg.MaxConnsPerHost = 10
+	if s := get("MAX_CONNS_PER_HOST"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid MAX_CONNS_PER_HOST: %q", s)
+		}
+		cfg.MaxConnsPerHost = n
+	}
+	if s := get("IDLE_CONN_TIMEOUT"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("invalid IDLE_CONN_TIMEOUT: %v", err)
+		}
+		cfg.IdleConnTimeout = d
+	} else {
+		cfg.IdleConnTimeout = 90 * time.Second
+	}
+	cfg.HTTP2PriorKnowledge = get("HTTP2_PRIOR_KNOWLEDGE") == "true"
+	cfg.LogTLSCertInfo = get("LOG_TLS_CERT_INFO") == "true"
+	cfg.TLSClientCertDir = get("TLS_CLIENT_CERT_DIR")
+	if cfg.TLSClientCertDir != "" {
+		if _, err := loadClientCert(cfg.TLSClientCertDir); err != nil {
+			log.Fatalf("invalid TLS_CLIENT_CERT_DIR: %v", err)
+		}
+	}
+	cfg.MaxResponseBytes = 64 << 20
+	if s := get("MAX_RESPONSE_BYTES"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 || n == math.MaxInt64 {
+			log.Fatalf("invalid MAX_RESPONSE_BYTES: %q", s)
+		}
+		cfg.MaxResponseBytes = n
+	}
+	if s := get("REQUESTS_PER_SECOND"); s != "" {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || f < 0 || math.IsInf(f, 0) {
+			log.Fatalf("invalid REQUESTS_PER_SECOND: %q", s)
+		}
+		cfg.RequestsPerSecond = f
+	}
+	cfg.RequestsBurst = 1
+	if s := get("REQUESTS_BURST"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid REQUESTS_BURST: %q", s)
+		}
+		cfg.RequestsBurst = n
+	}
+	if s := get("DECODE_RETRIES"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid DECODE_RETRIES: %q", s)
+		}
+		cfg.DecodeRetries = n
+	}
+	cfg.TableCostBreakdown = get("TABLE_COST_BREAKDOWN") == "true"
+
+	cfg.OTelMetricsEnabled = get("OTEL_METRICS_ENABLED") == "true"
+	cfg.OTelEndpoint = strings.TrimRight(get("OTEL_EXPORTER_OTLP_ENDPOINT"), "/")
+	if cfg.OTelEndpoint == "" {
+		cfg.OTelEndpoint = "http://localhost:4318"
+	}
+	if cfg.OTelMetricsEnabled {
+		if _, err := url.ParseRequestURI(cfg.OTelEndpoint); err != nil {
+			log.Fatalf("invalid OTEL_EXPORTER_OTLP_ENDPOINT: %v", err)
+		}
+	}
+	// Milliseconds, as in the OpenTelemetry SDK environment variable of the same name.
+	cfg.OTelExportInterval = time.Minute
+	if s := get("OTEL_METRIC_EXPORT_INTERVAL"); s != "" {
+		ms, err := strconv.Atoi(s)
+		if err != nil || ms <= 0 {
+			log.Fatalf("invalid OTEL_METRIC_EXPORT_INTERVAL: %q", s)
+		}
+		cfg.OTelExportInterval = time.Duration(ms) * time.Millisecond
+	}
+	cfg.PushOnlyChanged = get("PUSH_ONLY_CHANGED") == "true"
+
+	// Credentials in URLs (OPENCOST_URL, OTEL_EXPORTER_OTLP_ENDPOINT) and BACKFILL_TOKEN are not shown on /config.
+	for k, st := range settings {
+		if u, err := url.Parse(st.Value); err == nil && u.User != nil {
+			st.Value = u.Redacted()
+			settings[k] = st
+		}
+	}
+	if st := settings["BACKFILL_TOKEN"]; st.Value != "" {
+		st.Value = "xxxxx"
+		settings["BACKFILL_TOKEN"] = st
+	}
+	cfg.Settings = settings
+
+	return cfg
+}
+
+// processSettings apply to the whole process, so JOBS_FILE jobs may not override them.
+var processSettings = []string{
+	"ENV_PREFIX", "JOBS_FILE", "LISTEN_ADDR", "HEALTH_LISTEN_ADDR", "OUTPUT_FILE", "FAIL_ON_INITIAL_SCRAPE_ERROR",
+	"ENABLE_PPROF", "ENABLE_BACKFILL", "BACKFILL_TOKEN", "ENABLE_DEBUG_LASTSCRAPE", "OTEL_METRICS_ENABLED", "OTEL_EXPORTER_OTLP_ENDPOINT",
+	"OTEL_METRIC_EXPORT_INTERVAL", "PUSH_ONLY_CHANGED",
+}
+
+// jobsFile is the JOBS_FILE format. Each job runs its own exporter, configured by the environment with the
+// job's env entries (same names as the environment variables) on top.
+//
+//	jobs:
+//	  - name: prod
+//	    env:
+//	      OPENCOST_URL: http://opencost.prod:9003
+//	      WINDOW: 14d
+type jobsFile struct {
+	Jobs []struct {
+		Name string            `yaml:"name"`
+		Env  map[string]string `yaml:"env"`
+	} `yaml:"jobs"`
+}
+
+// mustJobs reads JOBS_FILE and returns the job names with their configs.
+func mustJobs(path string) ([]string, []config) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("invalid JOBS_FILE: %v", err)
+	}
+	var f jobsFile
+	if err := yaml.UnmarshalStrict(b, &f); err != nil {
+		log.Fatalf("invalid JOBS_FILE %s: %v", path, err)
+	}
+	if len(f.Jobs) == 0 {
+		log.Fatalf("invalid JOBS_FILE %s: no jobs", path)
+	}
+	names := make([]string, 0, len(f.Jobs))
+	cfgs := make([]config, 0, len(f.Jobs))
+	for _, j := range f.Jobs {
+		if j.Name == "" || slices.Contains(names, j.Name) {
+			log.Fatalf("invalid JOBS_FILE %s: job names must be set and unique, got %q", path, j.Name)
+		}
+		for _, k := range processSettings {
+			if _, ok := j.Env[k]; ok {
+				log.Fatalf("invalid JOBS_FILE %s: job %s sets %s, which applies to the whole process", path, j.Name, k)
+			}
+		}
+		// Config errors are fatal inside mustConfig, so say which job they belong to.
+		log.SetPrefix("job " + j.Name + ": ")
+		cfg := mustConfig(j.Env)
+		log.SetPrefix("")
+		if _, ok := cfg.ConstLabels["job"]; ok {
+			log.Fatalf("invalid JOBS_FILE %s: job %s sets the job label in CONST_LABELS", path, j.Name)
+		}
+		// Series of all jobs share one registry, which requires the same label names for a metric family.
+		if len(cfgs) > 0 && !slices.Equal(slices.Sorted(maps.Keys(cfg.ConstLabels)), slices.Sorted(maps.Keys(cfgs[0].ConstLabels))) {
+			log.Fatalf("invalid JOBS_FILE %s: job %s has different CONST_LABELS names than job %s", path, j.Name, names[0])
+		}
+		names = append(names, j.Name)
+		cfgs = append(cfgs, cfg)
+	}
+	return names, cfgs
+}
+
+type exporter struct {
+	cfg config
+	job string // JOBS_FILE job name, "" without JOBS_FILE
+	cli *http.Client
+	now func() time.Time
+	reg *prometheus.Registry
+
+	// after is time.After, replaceable so tests can observe the refresh loop's delays.
+	after func(time.Duration) <-chan time.Time
+
+	// limiter enforces REQUESTS_PER_SECOND across all requests of the exporter; nil without a limit.
+	limiter *rateLimiter
+
+	// queryWindow is the window sent to OpenCost for the scrape in progress (cfg.Window, or the resolved
+	// range in computed mode). Only scrape and the fetchers it calls use it.
+	queryWindow string
+	// dailyQueryWindow is the same for the graph endpoint (cfg.DailyWindow).
+	dailyQueryWindow string
+
+	scrapeSuccess      prometheus.Gauge
+	scrapeDuration     prometheus.Gauge
+	httpSeconds        prometheus.Gauge
+	processSeconds     prometheus.Gauge
+	heartbeat          prometheus.Gauge
+	scrapeOverrun      prometheus.Gauge
+	scrapeSkipped      prometheus.Counter
+	tickInterval       prometheus.Gauge
+	seriesExceeded     prometheus.Counter
+	connReused         prometheus.Counter
+	connNew            prometheus.Counter
+	tlsCertExpiry      prometheus.Gauge
+	apiCalls           *prometheus.CounterVec
+	rateLimited        prometheus.Counter
+	decodeDuration     *prometheus.GaugeVec
+	decodeErrors       *prometheus.CounterVec
+	bodyCodeMismatch   *prometheus.CounterVec
+	endpointLastOK     *prometheus.GaugeVec
+	cloudIntegrationUp *prometheus.GaugeVec
+	cloudIntegrationTS *prometheus.GaugeVec
+	cloudIntegrationCS *prometheus.GaugeVec
+	integrations       *prometheus.GaugeVec
+	integrationsStale  *prometheus.GaugeVec
+	upBySource         *prometheus.GaugeVec
+	cloudTotalCost     *prometheus.GaugeVec
+	cloudTotalCostEMA  *prometheus.GaugeVec
+	cloudCostRatio     *prometheus.GaugeVec
+	cloudWeekdayCost   *prometheus.GaugeVec
+	dailyDaysReturned  *prometheus.GaugeVec
+	dailyOldestDay     *prometheus.GaugeVec
+	dailyNewestDay     *prometheus.GaugeVec
+	dailyReconciled    *prometheus.GaugeVec
+	costMetricInvalid  *prometheus.GaugeVec
+	tableRows          *prometheus.GaugeVec
+	tableTruncated     *prometheus.GaugeVec
+	tableCoverage      *prometheus.GaugeVec
+	cloudAggCost       *prometheus.GaugeVec
+	cloudAggK8sPct     *prometheus.GaugeVec
+	cloudAggShare      *prometheus.GaugeVec
+	cloudAggCostByType *prometheus.GaugeVec
+	cloudCredits       *prometheus.GaugeVec
+	cloudServiceCost   *prometheus.GaugeVec
+	cloudServiceK8sPct *prometheus.GaugeVec
+	cloudK8sCost       *prometheus.GaugeVec
+	cloudNonK8sCost    *prometheus.GaugeVec
+	cloudCategoryCost  *prometheus.GaugeVec
+	cloudCategoryK8s   *prometheus.GaugeVec
+	cloudProviderCost  *prometheus.GaugeVec
+	cloudItemCost      *prometheus.GaugeVec
+
+	// consecutiveFailures counts failed scrapes since the last success (only touched by scrape).
+	consecutiveFailures      int
+	consecutiveFailuresGauge prometheus.Gauge
+
+	// refreshDelay is the delay (nanoseconds) from the start of a scrape to the next refresh, set by scrape from
+	// the circuit breaker (or SCRAPE_ALIGN_TO_NEXT_RUN) and read by the refresh loop once that scrape finished.
+	refreshDelay atomic.Int64
+	circuitOpen  prometheus.Gauge
+
+	// windowEnd is the end (unix nanos) of the window the exported data covers, capped at the scrape time; the
+	// sample timestamp of the window cost families with SUMMARY_TIMESTAMPS.
+	windowEnd atomic.Int64
+
+	// nextRun is the earliest upcoming integration run seen by the scrape in progress, zero if none (only
+	// touched by scrape).
+	nextRun time.Time
+
+	// connStatusSeen remembers every connection_status observed per integration (provider/key), so the enum
+	// gauge keeps exporting 0 for past states instead of dropping them.
+	connStatusSeen map[string][]string
+
+	// invalidCostMetrics are cost metrics OpenCost rejected with SKIP_INVALID_COST_METRICS (only touched by scrape).
+	invalidCostMetrics map[string]bool
+
+	// namesSeen records when each name was last exported per [aggregate, cost metric], for EMIT_ZERO_FOR_MISSING
+	// (only touched by scrape).
+	namesSeen map[[2]string]map[string]time.Time
+
+	// totalEMA holds the running EMA of the total cost per cost metric (only touched by scrape).
+	totalEMA map[string]float64
+
+	// otlpPushed holds the value of every data point in the last successful OTLP push, by otlpPointKey, for
+	// PUSH_ONLY_CHANGED (only touched by the push loop).
+	otlpPushed map[string]float64
+
+	// openCostHealth caches the last OpenCost check done for /readyz.
+	openCostHealth struct {
+		mu      sync.Mutex
+		checked time.Time
+		err     error
+	}
+
+	// lastScrape holds the report of the last finished scrape for /debug/lastscrape.
+	lastScrape struct {
+		mu     sync.Mutex
+		report *lastScrapeReport
+	}
+
+	// tlsCertLogged is set once the OpenCost server certificate was logged (LOG_TLS_CERT_INFO).
+	tlsCertLogged atomic.Bool
+
+	// scrapeStartedAt is the start (unix nanos) of the scrape in progress, 0 when idle. Read by the watchdog.
+	scrapeStartedAt atomic.Int64
+
+	// Daily metrics need explicit sample timestamps (derived from the day) so time-based alerting (offset) works.
+	daily *dailyCollector
+}
+
+func newTransport(cfg config) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = cfg.MaxIdleConns
+	t.MaxConnsPerHost = cfg.MaxConnsPerHost
+	// All requests go to a single OpenCost host, so allow it to keep as many idle connections as it may open.
+	t.MaxIdleConnsPerHost = cfg.MaxConnsPerHost
+	if t.MaxIdleConnsPerHost == 0 {
+		t.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	}
+	t.IdleConnTimeout = cfg.IdleConnTimeout
+	if cfg.HTTP2PriorKnowledge {
+		// Without HTTP1 in the set, http:// URLs use unencrypted HTTP/2 directly and https:// URLs require h2.
+		var p http.Protocols
+		p.SetHTTP2(true)
+		p.SetUnencryptedHTTP2(true)
+		t.Protocols = &p
+	}
+	if cfg.SocketPath != "" {
+		var d net.Dialer
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", cfg.SocketPath)
+		}
+	}
+	return t
+}
+
+// newExporter registers the exporter's metrics on registry, labelled with job unless it is "".
+func newExporter(cfg config, registry *prometheus.Registry, job string) *exporter {
+	// Cost metrics carry the currency OpenCost reports them in.
+	costLabels := prometheus.Labels{"currency": cfg.Currency}
+	daily := newDailyCollector(costLabels, cfg.DisabledMetrics, cfg.DayLocation, cfg.MaxLabelValueLen)
+	e := &exporter{
+		cfg:   cfg,
+		cli:   &http.Client{Transport: newTransport(cfg)},
+		now:   time.Now,
+		after: time.After,
+		job:   job,
+		reg:   registry,
+		scrapeSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_exporter_scrape_success",
+			Help: "1 if the last scrape from OpenCost succeeded; 0 otherwise.",
+		}),
+		scrapeDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_exporter_scrape_duration_seconds",
+			Help: "Duration of the last scrape from OpenCost in seconds.",
+		}),
+		httpSeconds: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_exporter_http_seconds",
+			Help: "Time the last scrape spent waiting on OpenCost: sending requests (including REQUESTS_PER_SECOND delays) and reading responses.",
+		}),
+		processSeconds: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_exporter_process_seconds",
+			Help: "Time the last scrape spent outside OpenCost requests, decoding responses and updating metrics.",
+		}),
+		scrapeOverrun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_exporter_scrape_overrun_seconds",
+			Help: "How much longer than REFRESH_INTERVAL the last scrape took in seconds (0 if it finished in time).",
+		}),
+		scrapeSkipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "opencost_cloudcost_exporter_scrape_skipped_total",
+			Help: "Refresh ticks skipped because the previous scrape was still running.",
+		}),
+		tickInterval: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_exporter_tick_interval_seconds",
+			Help: "Measured time between the last two refresh ticks; far from the expected delay (REFRESH_INTERVAL, jitter, backoff) indicates GC pauses or CPU throttling.",
+		}),
+		seriesExceeded: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "opencost_cloudcost_exporter_cardinality_exceeded_total",
+			Help: "Scrapes rejected because they would export more than MAX_SERIES_PER_SCRAPE series.",
+		}),
+		connReused: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "opencost_cloudcost_exporter_conn_reused_total",
+			Help: "Requests to OpenCost that reused a kept-alive connection.",
+		}),
+		connNew: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "opencost_cloudcost_exporter_conn_new_total",
+			Help: "Requests to OpenCost that had to open a new connection.",
+		}),
+		tlsCertExpiry: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_exporter_tls_cert_expiry_seconds",
+			Help: "Unix time at which the OpenCost server certificate seen on the last TLS connection expires (NotAfter).",
+		}),
+		heartbeat: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_exporter_heartbeat",
+			Help: "Unix time at which the last scrape started, whether or not it succeeded.",
+		}),
+		circuitOpen: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_exporter_circuit_open",
+			Help: "1 while the circuit breaker backs off refreshes after CIRCUIT_BREAKER_THRESHOLD consecutive failures; 0 otherwise.",
+		}),
+		consecutiveFailuresGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_exporter_consecutive_failures",
+			Help: "Number of consecutive failed scrapes from OpenCost; 0 after a successful scrape.",
+		}),
+		apiCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "opencost_cloudcost_exporter_api_calls_total",
+			Help: "Requests made to each OpenCost endpoint (including /readyz checks of status).",
+		}, []string{"endpoint"}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "opencost_cloudcost_exporter_rate_limited_total",
+			Help: "Requests to OpenCost that were delayed by REQUESTS_PER_SECOND.",
+		}),
+		decodeDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_exporter_decode_duration_seconds",
+			Help: "Time spent parsing the last JSON response from each OpenCost endpoint, excluding reading it from the network.",
+		}, []string{"endpoint"}),
+		decodeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "opencost_cloudcost_exporter_decode_errors_total",
+			Help: "Responses from each OpenCost endpoint whose body could not be decoded, including ones that were retried.",
+		}, []string{"endpoint"}),
+		bodyCodeMismatch: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "opencost_cloudcost_exporter_body_code_mismatch_total",
+			Help: "Responses where OpenCost returned HTTP 2xx but a non-200 code in the JSON body.",
+		}, []string{"endpoint"}),
+		endpointLastOK: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_exporter_endpoint_last_success_seconds",
+			Help: "Unix time of the last successful fetch from each OpenCost endpoint.",
+		}, []string{"endpoint"}),
+		cloudIntegrationUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_integration_up",
+			Help: "1 if the configured Cloud Cost integration is active+valid; 0 otherwise.",
+		}, []string{"key", "provider", "source", "connection_status"}),
+		cloudIntegrationTS: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_integration_run_timestamp",
+			Help: "Timestamps (unix seconds) for cloud cost integration runs and, with INTEGRATION_TIMESTAMPS, other status timestamps.",
+		}, []string{"key", "provider", "which"}),
+		cloudIntegrationCS: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_integration_connection_status",
+			Help: "1 for the current connection status of a cloud cost integration; 0 for previously seen statuses.",
+		}, []string{"key", "provider", "connection_status"}),
+		connStatusSeen: map[string][]string{},
+		integrations: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_integrations",
+			Help: "Number of distinct cloud cost integrations reported by OpenCost.",
+		}, []string{"provider"}),
+		integrationsStale: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_integrations_stale",
+			Help: "Number of cloud cost integrations whose last run is missing or older than INTEGRATION_STALE_AFTER.",
+		}, []string{"provider"}),
+		upBySource: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_integration_up_by_source",
+			Help: "1 if at least one cloud cost integration of the provider and source (e.g. CUR vs billing export) is up; 0 if all are down.",
+		}, []string{"provider", "source"}),
+		cloudTotalCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "opencost_cloudcost_total_cost",
+			Help:        "Total cloud cost over the configured window.",
+			ConstLabels: costLabels,
+		}, []string{"window", "cost_metric"}),
+		cloudTotalCostEMA: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "opencost_cloudcost_total_cost_ema",
+			Help:        "Exponential moving average (EMA_ALPHA) of the total cloud cost across scrapes; derived and approximate.",
+			ConstLabels: costLabels,
+		}, []string{"window", "cost_metric"}),
+		totalEMA: map[string]float64{},
+		cloudWeekdayCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "opencost_cloudcost_cost_by_weekday",
+			Help:        "Total cloud cost of the daily series summed by day of week (WEEKDAY_BREAKDOWN).",
+			ConstLabels: costLabels,
+		}, []string{"weekday", "window", "cost_metric"}),
+		costMetricInvalid: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_exporter_cost_metric_invalid",
+			Help: "1 for each configured cost metric that OpenCost rejected and that is no longer scraped (SKIP_INVALID_COST_METRICS).",
+		}, []string{"cost_metric"}),
+		invalidCostMetrics: map[string]bool{},
+		namesSeen:          map[[2]string]map[string]time.Time{},
+		dailyDaysReturned: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_daily_days_returned",
+			Help: "Number of distinct days OpenCost returned for the daily series; fewer than the window length signals data gaps.",
+		}, []string{"window", "cost_metric"}),
+		dailyOldestDay: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_daily_oldest_day_seconds",
+			Help: "Unix time of the oldest day OpenCost returned for the daily series.",
+		}, []string{"window", "cost_metric"}),
+		dailyNewestDay: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_daily_newest_day_seconds",
+			Help: "Unix time of the newest day OpenCost returned for the daily series; far in the past means OpenCost stopped ingesting.",
+		}, []string{"window", "cost_metric"}),
+		dailyReconciled: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_daily_reconciled",
+			Help: "1 if the day ended more than DAILY_RECONCILED_AFTER ago and its costs are assumed final; 0 while they may still be estimates.",
+		}, []string{"day", "window"}),
+		cloudCostRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_cost_ratio",
+			Help: "Ratio of total cost between two cost metrics (COST_RATIO_PAIRS), e.g. amortizedNetCost/listCost.",
+		}, []string{"numerator", "denominator", "window"}),
+		tableRows: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_table_rows",
+			Help: "Rows OpenCost returned from /cloudCost/view/table per aggregate.",
+		}, []string{"aggregate", "cost_metric"}),
+		tableTruncated: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_table_truncated",
+			Help: "1 if the /cloudCost/view/table response hit the 500 row limit, so more rows likely exist; 0 otherwise.",
+		}, []string{"aggregate", "cost_metric"}),
+		tableCoverage: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_table_coverage_ratio",
+			Help: "Sum of the exported /cloudCost/view/table row costs divided by the totals cost; below 1 when rows were truncated or filtered out.",
+		}, []string{"aggregate", "cost_metric"}),
+		cloudAggCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "opencost_cloudcost_aggregate_cost",
+			Help:        "Cloud cost by aggregate property over the configured window.",
+			ConstLabels: costLabels,
+		}, []string{"aggregate", "name", "window", "cost_metric"}),
+		cloudCredits: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "opencost_cloudcost_credits",
+			Help:        "Credits and refunds by aggregate property over the configured window: the negative cost OpenCost returned, as a positive amount.",
+			ConstLabels: costLabels,
+		}, []string{"aggregate", "name", "window", "cost_metric"}),
+		cloudAggK8sPct: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_aggregate_kubernetes_percent",
+			Help: "KubernetesPercent by aggregate property over the configured window.",
+		}, []string{"aggregate", "name", "window", "cost_metric"}),
+		cloudAggShare: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_aggregate_cost_share",
+			Help: "Cloud cost by aggregate property as a percentage of the window total (from /cloudCost/view/totals).",
+		}, []string{"aggregate", "name", "window", "cost_metric"}),
+		cloudAggCostByType: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "opencost_cloudcost_aggregate_cost_by_type",
+			Help:        "Cloud cost by aggregate property per cost type, from the breakdown fields of /cloudCost/view/table rows.",
+			ConstLabels: costLabels,
+		}, []string{"aggregate", "name", "cost_type", "window"}),
+		cloudServiceCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "opencost_cloudcost_service_cost",
+			Help:        "Cloud cost by service over the configured window.",
+			ConstLabels: costLabels,
+		}, []string{"service", "window", "cost_metric"}),
+		cloudServiceK8sPct: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_service_kubernetes_percent",
+			Help: "KubernetesPercent by service over the configured window.",
+		}, []string{"service", "window", "cost_metric"}),
+		cloudK8sCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "opencost_cloudcost_kubernetes_cost",
+			Help:        "Cloud cost attributable to Kubernetes over the configured window: the sum of cost times KubernetesPercent over the service rows.",
+			ConstLabels: costLabels,
+		}, []string{"window", "cost_metric"}),
+		cloudNonK8sCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "opencost_cloudcost_non_kubernetes_cost",
+			Help:        "Cloud cost not attributable to Kubernetes over the configured window: the rest of the service rows' cost.",
+			ConstLabels: costLabels,
+		}, []string{"window", "cost_metric"}),
+		cloudCategoryCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "opencost_cloudcost_category_cost",
+			Help:        "Cloud cost by category (resource type) over the configured window.",
+			ConstLabels: costLabels,
+		}, []string{"category", "window", "cost_metric"}),
+		cloudCategoryK8s: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "opencost_cloudcost_category_kubernetes_percent",
+			Help: "KubernetesPercent by category (resource type) over the configured window.",
+		}, []string{"category", "window", "cost_metric"}),
+		cloudProviderCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "opencost_cloudcost_provider_cost",
+			Help:        "Cloud cost by provider over the configured window (requires the provider aggregate).",
+			ConstLabels: costLabels,
+		}, []string{"provider", "window", "cost_metric"}),
+		cloudItemCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "opencost_cloudcost_item_cost",
+			Help:        "Cloud cost by item over the configured window, with the parts of the item name as labels (ITEM_SPLIT, requires the item aggregate).",
+			ConstLabels: costLabels,
+		}, []string{"invoice_entity_id", "account_id", "provider", "provider_id", "category", "service", "window", "cost_metric"}),
+		daily: daily,
+	}
+
+	// Static info metrics describing what this exporter scrapes.
+	costMetricInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "opencost_cloudcost_exporter_cost_metric_info",
+		Help: "1 for each cost metric this exporter scrapes.",
+	}, []string{"cost_metric"})
+	for _, cm := range cfg.CostMetrics {
+		costMetricInfo.WithLabelValues(cm).Set(1)
+	}
+	aggregateInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "opencost_cloudcost_exporter_aggregate_info",
+		Help: "1 for each aggregate this exporter scrapes.",
+	}, []string{"aggregate"})
+	for _, agg := range cfg.Aggregates {
+		aggregateInfo.WithLabelValues(agg).Set(1)
+	}
+
+	// Where each setting came from, e.g. to tell why WINDOW isn't what was set in the environment.
+	configSource := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "opencost_cloudcost_exporter_config_source",
+		Help: "1 for each setting with the source of its value: file (JOBS_FILE), env or default.",
+	}, []string{"field", "source"})
+	for k, st := range cfg.Settings {
+		configSource.WithLabelValues(k, st.Source).Set(1)
+	}
+
+	// Whether cost queries ask OpenCost for reconciled data; -1 when RECONCILE is unset (OpenCost's default applies).
+	reconcile := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "opencost_cloudcost_exporter_reconcile",
+		Help: "1 if cost queries request reconciled data, 0 if not, -1 if RECONCILE is unset and OpenCost's default applies.",
+	})
+	reconcile.Set(-1)
+	if cfg.Reconcile != nil {
+		reconcile.Set(0)
+		if *cfg.Reconcile {
+			reconcile.Set(1)
+		}
+	}
+
+	// CONST_LABELS (and the job name) are attached to every exporter metric by wrapping the registerer.
+	labels := maps.Clone(cfg.ConstLabels)
+	if job != "" {
+		if labels == nil {
+			labels = prometheus.Labels{}
+		}
+		labels["job"] = job
+	}
+	reg := prometheus.WrapRegistererWith(labels, e.reg)
+	// DISABLED_METRICS families are never registered; known collects every family name for validating it.
+	known := map[string]bool{}
+	registerIf := func(enabled bool, name string, c prometheus.Collector) {
+		known[name] = true
+		if enabled && !cfg.DisabledMetrics[name] {
+			reg.MustRegister(c)
+		}
+	}
+	register := func(name string, c prometheus.Collector) {
+		registerIf(true, name, c)
+	}
+	// With SUMMARY_TIMESTAMPS, the window cost families are exported with the window end as timestamp.
+	summary := func(c prometheus.Collector) prometheus.Collector {
+		if !cfg.SummaryTimestamps {
+			return c
+		}
+		return timestampedCollector{Collector: c, ts: func() int64 { return e.windowEnd.Load() }}
+	}
+	register("opencost_cloudcost_exporter_scrape_success", e.scrapeSuccess)
+	register("opencost_cloudcost_exporter_scrape_duration_seconds", e.scrapeDuration)
+	register("opencost_cloudcost_exporter_http_seconds", e.httpSeconds)
+	register("opencost_cloudcost_exporter_process_seconds", e.processSeconds)
+	register("opencost_cloudcost_exporter_heartbeat", e.heartbeat)
+	register("opencost_cloudcost_exporter_scrape_overrun_seconds", e.scrapeOverrun)
+	register("opencost_cloudcost_exporter_scrape_skipped_total", e.scrapeSkipped)
+	register("opencost_cloudcost_exporter_tick_interval_seconds", e.tickInterval)
+	register("opencost_cloudcost_exporter_cardinality_exceeded_total", e.seriesExceeded)
+	register("opencost_cloudcost_exporter_conn_reused_total", e.connReused)
+	register("opencost_cloudcost_exporter_conn_new_total", e.connNew)
+	registerIf(cfg.LogTLSCertInfo, "opencost_cloudcost_exporter_tls_cert_expiry_seconds", e.tlsCertExpiry)
+	register("opencost_cloudcost_exporter_consecutive_failures", e.consecutiveFailuresGauge)
+	registerIf(cfg.BreakerThreshold > 0, "opencost_cloudcost_exporter_circuit_open", e.circuitOpen)
+	register("opencost_cloudcost_exporter_api_calls_total", e.apiCalls)
+	registerIf(cfg.RequestsPerSecond > 0, "opencost_cloudcost_exporter_rate_limited_total", e.rateLimited)
+	register("opencost_cloudcost_exporter_decode_duration_seconds", e.decodeDuration)
+	register("opencost_cloudcost_exporter_decode_errors_total", e.decodeErrors)
+	register("opencost_cloudcost_exporter_body_code_mismatch_total", e.bodyCodeMismatch)
+	register("opencost_cloudcost_exporter_endpoint_last_success_seconds", e.endpointLastOK)
+	register("opencost_cloudcost_integration_up", e.cloudIntegrationUp)
+	register("opencost_cloudcost_integration_run_timestamp", e.cloudIntegrationTS)
+	register("opencost_cloudcost_integration_connection_status", e.cloudIntegrationCS)
+	register("opencost_cloudcost_integrations", e.integrations)
+	register("opencost_cloudcost_integrations_stale", e.integrationsStale)
+	register("opencost_cloudcost_integration_up_by_source", e.upBySource)
+	// With STATUS_ONLY, only the integration and exporter families are registered.
+	costs := !cfg.StatusOnly
+	registerIf(costs, "opencost_cloudcost_total_cost", summary(e.cloudTotalCost))
+	registerIf(costs, "opencost_cloudcost_daily_days_returned", e.dailyDaysReturned)
+	registerIf(costs, "opencost_cloudcost_daily_oldest_day_seconds", e.dailyOldestDay)
+	registerIf(costs, "opencost_cloudcost_daily_newest_day_seconds", e.dailyNewestDay)
+	registerIf(costs, "opencost_cloudcost_daily_reconciled", e.dailyReconciled)
+	registerIf(costs, "opencost_cloudcost_exporter_cost_metric_invalid", e.costMetricInvalid)
+	registerIf(costs && cfg.EMAAlpha > 0, "opencost_cloudcost_total_cost_ema", summary(e.cloudTotalCostEMA))
+	registerIf(costs && cfg.WeekdayBreakdown, "opencost_cloudcost_cost_by_weekday", e.cloudWeekdayCost)
+	registerIf(costs && len(cfg.CostRatioPairs) > 0, "opencost_cloudcost_cost_ratio", summary(e.cloudCostRatio))
+	registerIf(costs, "opencost_cloudcost_table_rows", e.tableRows)
+	registerIf(costs, "opencost_cloudcost_table_truncated", e.tableTruncated)
+	registerIf(costs, "opencost_cloudcost_table_coverage_ratio", e.tableCoverage)
+	registerIf(costs, "opencost_cloudcost_aggregate_cost", summary(e.cloudAggCost))
+	registerIf(costs, "opencost_cloudcost_credits", summary(e.cloudCredits))
+	registerIf(costs, "opencost_cloudcost_aggregate_kubernetes_percent", summary(e.cloudAggK8sPct))
+	registerIf(costs, "opencost_cloudcost_aggregate_cost_share", summary(e.cloudAggShare))
+	registerIf(costs && cfg.TableCostBreakdown, "opencost_cloudcost_aggregate_cost_by_type", summary(e.cloudAggCostByType))
+	registerIf(costs, "opencost_cloudcost_service_cost", summary(e.cloudServiceCost))
+	registerIf(costs, "opencost_cloudcost_service_kubernetes_percent", summary(e.cloudServiceK8sPct))
+	registerIf(costs, "opencost_cloudcost_kubernetes_cost", summary(e.cloudK8sCost))
+	registerIf(costs, "opencost_cloudcost_non_kubernetes_cost", summary(e.cloudNonK8sCost))
+	registerIf(costs, "opencost_cloudcost_category_cost", summary(e.cloudCategoryCost))
+	registerIf(costs, "opencost_cloudcost_category_kubernetes_percent", summary(e.cloudCategoryK8s))
+	registerIf(costs, "opencost_cloudcost_provider_cost", summary(e.cloudProviderCost))
+	registerIf(costs && cfg.ItemSplit, "opencost_cloudcost_item_cost", summary(e.cloudItemCost))
+	// The daily collector exports several families and drops disabled ones itself.
+	for _, name := range e.daily.names() {
+		known[name] = true
+	}
+	if costs {
+		reg.MustRegister(e.daily)
+	}
+	registerIf(costs, "opencost_cloudcost_exporter_cost_metric_info", costMetricInfo)
+	registerIf(costs, "opencost_cloudcost_exporter_aggregate_info", aggregateInfo)
+	registerIf(costs, "opencost_cloudcost_exporter_reconcile", reconcile)
+	register("opencost_cloudcost_exporter_config_source", configSource)
+
+	for name := range cfg.DisabledMetrics {
+		if !known[name] {
+			log.Fatalf("invalid DISABLED_METRICS entry %q: unknown metric", name)
+		}
+	}
+
+	if cfg.LogTLSCertInfo || cfg.TLSClientCertDir != "" {
+		tc := &tls.Config{}
+		if cfg.LogTLSCertInfo {
+			// Certificates are still verified as usual; this only inspects them once verification passed.
+			tc.VerifyConnection = e.verifyConnection
+		}
+		if cfg.TLSClientCertDir != "" {
+			tc.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return loadClientCert(cfg.TLSClientCertDir)
+			}
+		}
+		e.cli.Transport.(*http.Transport).TLSClientConfig = tc
+	}
+
+	if cfg.RequestsPerSecond > 0 {
+		e.limiter = newRateLimiter(cfg.RequestsPerSecond, cfg.RequestsBurst)
+	}
+
+	e.refreshDelay.Store(int64(cfg.RefreshInterval))
+
+	return e
+}
+
+// apiURL joins an API path onto OPENCOST_URL, which may carry a base path (e.g. http://host/model).
+func (e *exporter) apiURL(path string, query url.Values) string {
+	u, err := url.Parse(e.cfg.OpenCostURL)
+	if err != nil {
+		// OPENCOST_URL is validated in mustConfig; fall back to plain concatenation.
+		return e.cfg.OpenCostURL + path + "?" + query.Encode()
+	}
+	u = u.JoinPath(path)
+	if query != nil && e.cfg.Reconcile != nil {
+		query.Set("reconcile", strconv.FormatBool(*e.cfg.Reconcile))
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (e *exporter) statusURL() string {
+	return e.apiURL("/cloudCost/status", nil)
+}
+
+func (e *exporter) totalsURL(costMetric string) string {
+	return e.apiURL("/cloudCost/view/totals", url.Values{
+		"window":     {e.queryWindow},
+		"aggregate":  {"service"},
+		"accumulate": {"day"},
+		"costMetric": {costMetric},
+	})
+}
+
+// tableLimit is the number of rows requested from /cloudCost/view/table.
+const tableLimit = 500
+
+func (e *exporter) tableURL(aggregate, costMetric string) string {
+	q := url.Values{
+		"window":      {e.queryWindow},
+		"accumulate":  {"day"},
+		"costMetric":  {costMetric},
+		"sortBy":      {"cost"},
+		"sortByOrder": {"desc"},
+		"limit":       {strconv.Itoa(tableLimit)},
+	}
+	// "item" (aka no aggregate param) returns fully-qualified names like:
+	// invoiceEntityID/accountID/provider/providerID/category/service
+	// which lets you break down by resource/providerID.
+	if aggregate != "item" {
+		q.Set("aggregate", aggregate)
+	}
+	return e.apiURL("/cloudCost/view/table", q)
+}
+
+func (e *exporter) graphURL(window, aggregate, costMetric string) string {
+	q := url.Values{
+		"window":     {window},
+		"accumulate": {"day"},
+		"costMetric": {costMetric},
+	}
+	if aggregate != "item" {
+		q.Set("aggregate", aggregate)
+	}
+	return e.apiURL("/cloudCost/view/graph", q)
+}
+
+// lastScrapeReport summarizes one scrape for /debug/lastscrape.
+type lastScrapeReport struct {
+	Timestamp       time.Time      `json:"timestamp"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Success         bool           `json:"success"`
+	Error           string         `json:"error,omitempty"`
+	Requests        []requestEntry `json:"requests"`
+	// Series counts the series per metric family the scrape fetched data for; unset if it failed before that.
+	Series map[string]int `json:"series,omitempty"`
+}
+
+type requestEntry struct {
+	Endpoint string `json:"endpoint"`
+	URL      string `json:"url"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+}
+
+// request records an OpenCost request made by the scrape. r may be nil (report disabled).
+func (r *lastScrapeReport) request(endpoint, rawURL string, err error) {
+	if r == nil {
+		return
+	}
+	if u, perr := url.Parse(rawURL); perr == nil {
+		rawURL = u.Redacted()
+	}
+	entry := requestEntry{Endpoint: endpoint, URL: rawURL, Success: err == nil}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	r.Requests = append(r.Requests, entry)
+}
+
+func (e *exporter) scrape(ctx context.Context) (err error) {
+	start := time.Now()
+	e.scrapeStartedAt.Store(start.UnixNano())
+	var report *lastScrapeReport
+	if e.cfg.EnableDebugLastScrape {
+		report = &lastScrapeReport{Timestamp: start.UTC(), Requests: []requestEntry{}}
+	}
+	// Dead-man's switch: advances on every tick, so a flat line means the refresh loop stopped.
+	began := e.now()
+	e.heartbeat.Set(float64(began.Unix()))
+	timing := new(httpTiming)
+	defer func() {
+		e.scrapeStartedAt.Store(0)
+		took := time.Since(start)
+		e.scrapeDuration.Set(took.Seconds())
+		httpTook := time.Duration(timing.Load())
+		e.httpSeconds.Set(httpTook.Seconds())
+		e.processSeconds.Set(max(0, took-httpTook).Seconds())
+		e.scrapeOverrun.Set(max(0, took-e.cfg.RefreshInterval).Seconds())
+		if err != nil {
+			e.consecutiveFailures++
+		} else {
+			e.consecutiveFailures = 0
+		}
+		e.consecutiveFailuresGauge.Set(float64(e.consecutiveFailures))
+		delay := breakerInterval(e.cfg.RefreshInterval, e.cfg.BreakerMaxInterval, e.cfg.BreakerThreshold, e.consecutiveFailures)
+		if delay > e.cfg.RefreshInterval {
+			e.circuitOpen.Set(1)
+		} else {
+			e.circuitOpen.Set(0)
+		}
+		// Without a successful scrape or an upcoming run (e.g. status unavailable), keep the fixed interval.
+		// Like the interval, the delay counts from the start of this scrape, which is when its tick fired.
+		if e.cfg.ScrapeAlignToNextRun && err == nil && !e.nextRun.IsZero() {
+			delay = e.nextRun.Sub(began) + e.cfg.ScrapeAlignDelay
+		}
+		e.refreshDelay.Store(int64(delay))
+		if report != nil {
+			report.DurationSeconds = took.Seconds()
+			report.Success = err == nil
+			if err != nil {
+				report.Error = err.Error()
+			}
+			e.lastScrape.mu.Lock()
+			e.lastScrape.report = report
+			e.lastScrape.mu.Unlock()
+		}
+	}()
+
+	// Fetches share a context cancelled as soon as scrape returns, so the first unrecoverable error also stops any
+	// fetch still in flight instead of leaving it to run until SCRAPE_TIMEOUT.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx = context.WithValue(ctx, httpTimingKey{}, timing)
+
+	// Reset only the series for this window/metric by wiping all and rebuilding.
+	// This exporter is intended to run with a single configured window, but may scrape multiple aggregates/cost metrics.
+	e.queryWindow = e.cfg.Window
+	if e.cfg.WindowRelative != "" {
+		winStart, winEnd, err := resolveRelativeWindow(e.cfg.WindowRelative, e.now())
+		if err != nil {
+			e.scrapeSuccess.Set(0)
+			return err
+		}
+		e.queryWindow = winStart.Format(time.RFC3339) + "," + winEnd.Format(time.RFC3339)
+	}
+	e.dailyQueryWindow = e.queryWindow
+	if e.cfg.DailyWindow != e.cfg.Window {
+		e.dailyQueryWindow = e.cfg.DailyWindow
+	}
+
+	// Integration status is the least important data for cost dashboards: if it is unavailable, log it, export
+	// no integration series this round and carry on with the cost endpoints.
+	status, statusErr := e.fetchStatus(ctx)
+	report.request("status", e.statusURL(), statusErr)
+	if statusErr != nil && e.cfg.StatusOnly {
+		e.scrapeSuccess.Set(0)
+		return statusErr
+	}
+	if statusErr != nil {
+		log.Printf("status fetch failed, skipping integration metrics: %v", statusErr)
+	}
+
+	// Fetch everything first and apply it only once the whole scrape succeeded and fits MAX_SERIES_PER_SCRAPE,
+	// so a rejected scrape keeps the previous data.
+	costMetrics := e.cfg.CostMetrics
+	if e.cfg.StatusOnly {
+		costMetrics = nil
+	}
+	var fetched []costData
+	// rejected maps the cost metrics OpenCost rejected this scrape, without naming them, to the rejection.
+	rejected := map[string]error{}
+	for _, costMetric := range costMetrics {
+		if e.invalidCostMetrics[costMetric] {
+			continue
+		}
+		totals, err := e.fetchTotals(ctx, costMetric)
+		report.request("totals", e.totalsURL(costMetric), err)
+		if err != nil && e.cfg.SkipInvalidCostMetrics && isBadRequest(err) {
+			// Most likely a misspelled cost metric: drop it for the life of the process instead of failing every
+			// scrape. A 400 can also come from a bad WINDOW or a broken proxy, so unless the error names the cost
+			// metric it is only dropped once another cost metric went through (below).
+			if namesCostMetric(err, costMetric) {
+				e.skipCostMetric(costMetric, err)
+			} else {
+				rejected[costMetric] = err
+			}
+			continue
+		}
+		if err != nil {
+			e.scrapeSuccess.Set(0)
+			return err
+		}
+		cd := costData{costMetric: costMetric, total: totals}
+
+		var items []dailyPoint
+		if e.cfg.GraphDeriveFromItem {
+			items, err = e.fetchGraph(ctx, e.dailyQueryWindow, "item", costMetric)
+			report.request("graph", e.graphURL(e.dailyQueryWindow, "item", costMetric), err)
+			if err != nil {
+				e.scrapeSuccess.Set(0)
+				return err
+			}
+		}
+
+		// Scrape daily totals from the DAILY_TOTAL_AGGREGATE graph (used by dashboards, and gives a consistent
+		// total) unless AGGREGATES pins that aggregate to other cost metrics.
+		if e.cfg.scrapesAggregate(e.cfg.DailyTotalAggregate, costMetric) {
+			cd.daily, err = e.dailyFor(ctx, report, items, e.cfg.DailyTotalAggregate, costMetric)
+			if err != nil {
+				e.scrapeSuccess.Set(0)
+				return err
+			}
+		}
+
+		for _, agg := range e.cfg.Aggregates {
+			if !e.cfg.scrapesAggregate(agg, costMetric) {
+				continue
+			}
+			rows, returned, err := e.fetchTable(ctx, agg, costMetric)
+			report.request("table", e.tableURL(agg, costMetric), err)
+			if err != nil {
+				e.scrapeSuccess.Set(0)
+				return err
+			}
+			ad := aggregateData{aggregate: agg, rows: e.topRows(agg, e.filterRows(agg, rows)), returned: returned}
+			// Daily series for each aggregate (DAILY_TOTAL_AGGREGATE already scraped above).
+			if agg != e.cfg.DailyTotalAggregate {
+				ad.daily, err = e.dailyFor(ctx, report, items, agg, costMetric)
+				if err != nil {
+					e.scrapeSuccess.Set(0)
+					return err
+				}
+			}
+			cd.aggregates = append(cd.aggregates, ad)
+		}
+		fetched = append(fetched, cd)
+	}
+	if len(costMetrics) > 0 && len(fetched) == 0 {
+		// Every cost metric was rejected (or had been before), which points at the query rather than the metrics.
+		e.scrapeSuccess.Set(0)
+		return errors.New("OpenCost rejected every cost metric, check COST_METRICS and WINDOW")
+	}
+	for _, costMetric := range slices.Sorted(maps.Keys(rejected)) {
+		e.skipCostMetric(costMetric, rejected[costMetric])
+	}
+	// A malformed day fails the scrape here, before anything is reset, so the previous data stays.
+	for _, cd := range fetched {
+		if err := e.checkDays(cd); err != nil {
+			e.scrapeSuccess.Set(0)
+			return err
+		}
+	}
+
+	if report != nil {
+		report.Series = e.countSeries(fetched)
+	}
+	if e.cfg.MaxSeriesPerScrape > 0 {
+		counts := e.countSeries(fetched)
+		total, worst := 0, ""
+		for name, n := range counts {
+			total += n
+			if worst == "" || n > counts[worst] {
+				worst = name
+			}
+		}
+		if total > e.cfg.MaxSeriesPerScrape {
+			e.seriesExceeded.Inc()
+			e.scrapeSuccess.Set(0)
+			return fmt.Errorf("scrape would export %d series (MAX_SERIES_PER_SCRAPE=%d), keeping previous data; largest family %s has %d",
+				total, e.cfg.MaxSeriesPerScrape, worst, counts[worst])
+		}
+	}
+
+	e.cloudIntegrationUp.Reset()
+	e.cloudIntegrationTS.Reset()
+	e.cloudIntegrationCS.Reset()
+	e.integrations.Reset()
+	e.integrationsStale.Reset()
+	e.upBySource.Reset()
+	e.tableRows.Reset()
+	e.tableTruncated.Reset()
+	e.tableCoverage.Reset()
+	e.cloudAggCost.Reset()
+	e.cloudCredits.Reset()
+	e.cloudAggK8sPct.Reset()
+	e.cloudAggShare.Reset()
+	e.cloudAggCostByType.Reset()
+	e.cloudServiceCost.Reset()
+	e.cloudServiceK8sPct.Reset()
+	e.cloudK8sCost.Reset()
+	e.cloudNonK8sCost.Reset()
+	e.cloudCategoryCost.Reset()
+	e.cloudCategoryK8s.Reset()
+	e.cloudProviderCost.Reset()
+	e.cloudItemCost.Reset()
+	e.cloudCostRatio.Reset()
+	e.cloudWeekdayCost.Reset()
+	e.dailyDaysReturned.Reset()
+	e.dailyOldestDay.Reset()
+	e.dailyNewestDay.Reset()
+	e.dailyReconciled.Reset()
+	e.daily.Reset()
+	if e.cfg.DailyMaxBackfill > 0 {
+		e.daily.minTS = e.now().Add(-e.cfg.DailyMaxBackfill)
+	}
+
+	windowEnd := e.now()
+	if _, end, _ := validateWindow(e.queryWindow); !end.IsZero() && end.Before(windowEnd) {
+		windowEnd = end
+	}
+	e.windowEnd.Store(windowEnd.UnixNano())
+
+	e.nextRun = time.Time{}
+	if statusErr == nil {
+		e.applyStatus(status)
+	}
+
+	totalsByMetric := make(map[string]float64, len(fetched))
+	for _, cd := range fetched {
+		costMetric := cd.costMetric
+		totals := cd.total
+		totalsByMetric[costMetric] = totals
+		e.cloudTotalCost.WithLabelValues(e.cfg.Window, costMetric).Set(totals)
+		if e.cfg.EMAAlpha > 0 {
+			ema, ok := e.totalEMA[costMetric]
+			if !ok {
+				ema = totals
+			}
+			ema = e.cfg.EMAAlpha*totals + (1-e.cfg.EMAAlpha)*ema
+			e.totalEMA[costMetric] = ema
+			e.cloudTotalCostEMA.WithLabelValues(e.cfg.Window, costMetric).Set(ema)
+		}
+
+		days := make(map[string]bool, len(cd.daily))
+		var oldest, newest time.Time
+		for _, d := range cd.daily {
+			days[d.Day] = true
+			// checkDays already rejected invalid days.
+			if ts, err := parseDay(d.Day, e.cfg.DayLocation); err == nil {
+				// Heuristic: OpenCost has no per-day completeness flag, so a day counts as reconciled once it
+				// ended DAILY_RECONCILED_AFTER ago.
+				reconciled := 0.0
+				if e.now().Sub(ts.AddDate(0, 0, 1)) >= e.cfg.DailyReconciledAfter {
+					reconciled = 1
+				}
+				e.dailyReconciled.WithLabelValues(d.Day, e.cfg.DailyWindow).Set(reconciled)
+				if oldest.IsZero() || ts.Before(oldest) {
+					oldest = ts
+				}
+				if ts.After(newest) {
+					newest = ts
+				}
+			}
+		}
+		if e.cfg.scrapesAggregate(e.cfg.DailyTotalAggregate, costMetric) {
+			e.dailyDaysReturned.WithLabelValues(e.cfg.DailyWindow, costMetric).Set(float64(len(days)))
+		}
+		if !newest.IsZero() {
+			e.dailyOldestDay.WithLabelValues(e.cfg.DailyWindow, costMetric).Set(float64(oldest.Unix()))
+			e.dailyNewestDay.WithLabelValues(e.cfg.DailyWindow, costMetric).Set(float64(newest.Unix()))
+		}
+		for _, d := range cd.daily {
+			day := d.Day
+			if err := e.daily.SetTotalCost(day, e.cfg.DailyWindow, costMetric, d.Total); err != nil {
+				e.scrapeSuccess.Set(0)
+				return err
+			}
+			if e.cfg.WeekdayBreakdown {
+				// checkDays already rejected invalid days.
+				ts, _ := parseDay(day, e.cfg.DayLocation)
+				e.cloudWeekdayCost.WithLabelValues(ts.Weekday().String(), e.cfg.DailyWindow, costMetric).Add(d.Total)
+			}
+		}
+		if err := e.setDailyAggregate(e.cfg.DailyTotalAggregate, costMetric, cd.daily); err != nil {
+			e.scrapeSuccess.Set(0)
+			return err
+		}
+
+		for _, ad := range cd.aggregates {
+			agg := ad.aggregate
+			// OpenCost's table response has no total-count field, so the rows beyond the limit can't be counted
+			// (there is no table_total_rows); the truncation flag is the fallback.
+			e.tableRows.WithLabelValues(agg, costMetric).Set(float64(ad.returned))
+			truncated := 0.0
+			if ad.returned >= tableLimit {
+				truncated = 1
+			}
+			e.tableTruncated.WithLabelValues(agg, costMetric).Set(truncated)
+			// Absolute Kubernetes vs. other split of the service rows; KubernetesPercent is a 0-1 fraction and 0
+			// when OpenCost leaves it out.
+			var k8sCost, nonK8sCost, rowSum float64
+			malformedItems := 0
+			for _, r := range ad.rows {
+				name := truncateLabelValue(r.Name, e.cfg.MaxLabelValueLen)
+				rowSum += r.Cost
+				cost := r.Cost
+				if cost < 0 {
+					e.cloudCredits.WithLabelValues(agg, name, e.cfg.Window, costMetric).Set(-cost)
+					if e.cfg.ClampNegativeCosts {
+						cost = 0
+					}
+				}
+				e.cloudAggCost.WithLabelValues(agg, name, e.cfg.Window, costMetric).Set(cost)
+				e.cloudAggK8sPct.WithLabelValues(agg, name, e.cfg.Window, costMetric).Set(r.KubernetesPercent)
+				// The breakdown doesn't depend on the queried cost metric, so rows fetched for several cost metrics
+				// set the same series.
+				for costType, v := range r.CostByType {
+					e.cloudAggCostByType.WithLabelValues(agg, name, costType, e.cfg.Window).Set(v)
+				}
+				// No share when the total is zero (e.g. an empty window) rather than exporting +Inf/NaN.
+				if totals != 0 {
+					e.cloudAggShare.WithLabelValues(agg, name, e.cfg.Window, costMetric).Set(cost / totals * 100)
+				}
+
+				if agg == "service" {
+					e.cloudServiceCost.WithLabelValues(name, e.cfg.Window, costMetric).Set(cost)
+					e.cloudServiceK8sPct.WithLabelValues(name, e.cfg.Window, costMetric).Set(r.KubernetesPercent)
+					k8sCost += cost * r.KubernetesPercent
+					nonK8sCost += cost * (1 - r.KubernetesPercent)
+				}
+				if agg == "category" {
+					e.cloudCategoryCost.WithLabelValues(name, e.cfg.Window, costMetric).Set(cost)
+					e.cloudCategoryK8s.WithLabelValues(name, e.cfg.Window, costMetric).Set(r.KubernetesPercent)
+				}
+				if agg == "provider" {
+					e.cloudProviderCost.WithLabelValues(name, e.cfg.Window, costMetric).Set(cost)
+				}
+				// Rolled-up rows (__other__) have no item parts and stay on aggregate_cost only.
+				if agg == "item" && e.cfg.ItemSplit && r.Name != otherName {
+					if parts, ok := splitItem(r.Name); ok {
+						lvs := make([]string, 0, len(parts)+2)
+						for i, part := range parts {
+							lvs = append(lvs, truncateLabelValue(e.normalizeName(itemFields[i], part), e.cfg.MaxLabelValueLen))
+						}
+						e.cloudItemCost.WithLabelValues(append(lvs, e.cfg.Window, costMetric)...).Set(cost)
+					} else {
+						malformedItems++
+					}
+				}
+			}
+			if malformedItems > 0 {
+				log.Printf("%d item names of %s don't have the expected %d parts, left out of opencost_cloudcost_item_cost",
+					malformedItems, costMetric, len(itemFields))
+			}
+			if agg == "service" {
+				e.cloudK8sCost.WithLabelValues(e.cfg.Window, costMetric).Set(k8sCost)
+				e.cloudNonK8sCost.WithLabelValues(e.cfg.Window, costMetric).Set(nonK8sCost)
+			}
+			// Row costs before CLAMP_NEGATIVE_COSTS, to compare like for like with the total; none for a zero total.
+			if totals != 0 {
+				e.tableCoverage.WithLabelValues(agg, costMetric).Set(rowSum / totals)
+			}
+
+			if e.cfg.EmitZeroForMissing {
+				e.zeroMissingNames(agg, costMetric, ad.rows)
+			}
+
+			if err := e.setDailyAggregate(agg, costMetric, ad.daily); err != nil {
+				e.scrapeSuccess.Set(0)
+				return err
+			}
+		}
+	}
+
+	if e.daily.dropped > 0 {
+		log.Printf("dropped %d daily samples older than DAILY_MAX_BACKFILL=%s", e.daily.dropped, e.cfg.DailyMaxBackfill)
+	}
+
+	for _, p := range e.cfg.CostRatioPairs {
+		// No series when the denominator is zero (e.g. an empty window) rather than exporting +Inf/NaN.
+		if den := totalsByMetric[p[1]]; den != 0 {
+			e.cloudCostRatio.WithLabelValues(p[0], p[1], e.cfg.Window).Set(totalsByMetric[p[0]] / den)
+		}
+	}
+
+	e.scrapeSuccess.Set(1)
+	return nil
+}
+
+// setDailyAggregate exports the per-name daily series of aggregate.
+func (e *exporter) setDailyAggregate(aggregate, costMetric string, points []dailyPoint) error {
+	for _, d := range points {
+		for name, v := range e.filterDaily(aggregate, d.ByService) {
+			if err := e.daily.SetAggCost(aggregate, name, d.Day, e.cfg.DailyWindow, costMetric, v); err != nil {
+				return err
+			}
+			if aggregate == "service" {
+				if err := e.daily.SetServiceCost(name, d.Day, e.cfg.DailyWindow, costMetric, v); err != nil {
+					return err
+				}
+			}
+			if aggregate == "category" {
+				if err := e.daily.SetCategoryCost(name, d.Day, e.cfg.DailyWindow, costMetric, v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// costData is what a scrape fetched for one cost metric, before it is applied to the metrics.
+type costData struct {
+	costMetric string
+	total      float64
+	daily      []dailyPoint // from the DAILY_TOTAL_AGGREGATE graph
+	aggregates []aggregateData
+}
+
+// aggregateData holds the filtered table rows and (except for DAILY_TOTAL_AGGREGATE, see costData.daily) the
+// daily series of one aggregate.
+type aggregateData struct {
+	aggregate string
+	rows      []tableRow
+	returned  int // rows OpenCost returned for the table, before merging and filtering
+	daily     []dailyPoint
+}
+
+// checkDays returns an error for the first day of cd's daily series that parseDay rejects.
+func (e *exporter) checkDays(cd costData) error {
+	points := slices.Clone(cd.daily)
+	for _, ad := range cd.aggregates {
+		points = append(points, ad.daily...)
+	}
+	for _, d := range points {
+		if _, err := parseDay(d.Day, e.cfg.DayLocation); err != nil {
+			return fmt.Errorf("invalid day %q in the %s graph: %w", d.Day, cd.costMetric, err)
+		}
+	}
+	return nil
+}
+
+// countSeries returns how many series applying fetched would export per cost family that grows with the
+// OpenCost data (names and days). Fixed-size families (totals, status, exporter metrics) are not counted.
+func (e *exporter) countSeries(fetched []costData) map[string]int {
+	counts := map[string]int{}
+	add := func(name string, n int) {
+		if !e.cfg.DisabledMetrics[name] {
+			counts[name] += n
+		}
+	}
+	// Mirrors setDailyAggregate.
+	addDaily := func(aggregate string, points []dailyPoint) {
+		for _, d := range points {
+			n := len(e.filterDaily(aggregate, d.ByService))
+			add("opencost_cloudcost_daily_aggregate_cost", n)
+			switch aggregate {
+			case "service":
+				add("opencost_cloudcost_daily_service_cost", n)
+			case "category":
+				add("opencost_cloudcost_daily_category_cost", n)
+			}
+		}
+	}
+	// Rows fetched for several cost metrics share their cost type breakdown series.
+	byType := map[[3]string]bool{}
+	for _, cd := range fetched {
+		add("opencost_cloudcost_daily_total_cost", len(cd.daily))
+		addDaily(e.cfg.DailyTotalAggregate, cd.daily)
+		for _, ad := range cd.aggregates {
+			add("opencost_cloudcost_aggregate_cost", len(ad.rows))
+			add("opencost_cloudcost_aggregate_kubernetes_percent", len(ad.rows))
+			for _, r := range ad.rows {
+				if r.Cost < 0 {
+					add("opencost_cloudcost_credits", 1)
+				}
+				if ad.aggregate == "item" && e.cfg.ItemSplit {
+					if _, ok := splitItem(r.Name); ok {
+						add("opencost_cloudcost_item_cost", 1)
+					}
+				}
+				for costType := range r.CostByType {
+					byType[[3]string{ad.aggregate, r.Name, costType}] = true
+				}
+			}
+			if cd.total != 0 {
+				add("opencost_cloudcost_aggregate_cost_share", len(ad.rows))
+			}
+			switch ad.aggregate {
+			case "service":
+				add("opencost_cloudcost_service_cost", len(ad.rows))
+				add("opencost_cloudcost_service_kubernetes_percent", len(ad.rows))
+				add("opencost_cloudcost_kubernetes_cost", 1)
+				add("opencost_cloudcost_non_kubernetes_cost", 1)
+			case "category":
+				add("opencost_cloudcost_category_cost", len(ad.rows))
+				add("opencost_cloudcost_category_kubernetes_percent", len(ad.rows))
+			case "provider":
+				add("opencost_cloudcost_provider_cost", len(ad.rows))
+			}
+			// Mirrors zeroMissingNames.
+			if e.cfg.EmitZeroForMissing {
+				n := len(e.missingNames(ad.aggregate, cd.costMetric, ad.rows))
+				add("opencost_cloudcost_aggregate_cost", n)
+				switch ad.aggregate {
+				case "service":
+					add("opencost_cloudcost_service_cost", n)
+				case "category":
+					add("opencost_cloudcost_category_cost", n)
+				case "provider":
+					add("opencost_cloudcost_provider_cost", n)
+				}
+			}
+			addDaily(ad.aggregate, ad.daily)
+		}
+	}
+	if e.cfg.TableCostBreakdown {
+		add("opencost_cloudcost_aggregate_cost_by_type", len(byType))
+	}
+	// Backfilled samples are exported next to the regular daily ones until the next /backfill.
+	for name, n := range e.daily.backfilledSeries() {
+		add(name, n)
+	}
+	return counts
+}
+
+// zeroMissingNames exports 0 for names seen in earlier scrapes of aggregate/costMetric but missing from rows, so
+// their series don't vanish. Names missing for longer than ZERO_FOR_MISSING_TTL are forgotten.
+func (e *exporter) zeroMissingNames(aggregate, costMetric string, rows []tableRow) {
+	key := [2]string{aggregate, costMetric}
+	seen := e.namesSeen[key]
+	if seen == nil {
+		seen = map[string]time.Time{}
+		e.namesSeen[key] = seen
+	}
+	now := e.now()
+	for _, r := range rows {
+		seen[r.Name] = now
+	}
+	for name, last := range seen {
+		if now.Sub(last) > e.cfg.ZeroForMissingTTL {
+			delete(seen, name)
+		}
+	}
+	for _, name := range e.missingNames(aggregate, costMetric, rows) {
+		label := truncateLabelValue(name, e.cfg.MaxLabelValueLen)
+		e.cloudAggCost.WithLabelValues(aggregate, label, e.cfg.Window, costMetric).Set(0)
+		switch aggregate {
+		case "service":
+			e.cloudServiceCost.WithLabelValues(label, e.cfg.Window, costMetric).Set(0)
+		case "category":
+			e.cloudCategoryCost.WithLabelValues(label, e.cfg.Window, costMetric).Set(0)
+		case "provider":
+			e.cloudProviderCost.WithLabelValues(label, e.cfg.Window, costMetric).Set(0)
+		}
+	}
+}
+
+// missingNames returns the names zeroMissingNames exports 0 for: seen in earlier scrapes of aggregate/costMetric
+// within ZERO_FOR_MISSING_TTL but missing from rows.
+func (e *exporter) missingNames(aggregate, costMetric string, rows []tableRow) []string {
+	present := make(map[string]bool, len(rows))
+	for _, r := range rows {
+		present[r.Name] = true
+	}
+	now := e.now()
+	var missing []string
+	for name, last := range e.namesSeen[[2]string{aggregate, costMetric}] {
+		if !present[name] && now.Sub(last) <= e.cfg.ZeroForMissingTTL {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// otherName is the series name that rolled-up rows (ROLLUP_OTHER, MIN_COST_THRESHOLD) are summed into.
+const otherName = "__other__"
+
+func (e *exporter) filterFor(aggregate string) nameFilter {
+	switch aggregate {
+	case "service":
+		return e.cfg.ServiceFilter
+	case "category":
+		return e.cfg.CategoryFilter
+	}
+	return nameFilter{}
+}
+
+// keep reports whether a row is emitted as-is and, if not, whether it is summed into "__other__".
+// Rows dropped by the allow/deny lists roll up only with ROLLUP_OTHER; rows under MIN_COST_THRESHOLD always roll up.
+func (e *exporter) keep(f nameFilter, name string, cost float64) (keep, rollup bool) {
+	if !f.match(name) {
+		return false, e.cfg.RollupOther
+	}
+	// Compare magnitudes so large credits (negative costs) are not hidden in the bucket.
+	if e.cfg.MinCostThreshold > 0 && math.Abs(cost) < e.cfg.MinCostThreshold {
+		return false, true
+	}
+	return true, false
+}
+
+func (e *exporter) filterRows(aggregate string, rows []tableRow) []tableRow {
+	f := e.filterFor(aggregate)
+	if f.empty() && e.cfg.MinCostThreshold <= 0 {
+		return rows
+	}
+	out := make([]tableRow, 0, len(rows))
+	var rolled []tableRow
+	for _, r := range rows {
+		keep, rollup := e.keep(f, r.Name, r.Cost)
+		if keep {
+			out = append(out, r)
+		} else if rollup {
+			rolled = append(rolled, r)
+		}
+	}
+	if len(rolled) > 0 {
+		out = append(out, rollupRows(rolled))
+	}
+	return out
+}
+
+// topRows keeps the AGGREGATE_TOP_N most expensive rows of aggregate by exported cost and sums the rest, including
+// any "__other__" row from filterRows, into "__other__". Rows are sorted here rather than trusting OpenCost's order,
+// which follows its own sort parameter and is changed by merged duplicate names.
+func (e *exporter) topRows(aggregate string, rows []tableRow) []tableRow {
+	n, ok := e.cfg.AggregateTopN[aggregate]
+	if !ok {
+		n, ok = e.cfg.AggregateTopN[""]
+	}
+	if !ok || len(rows) <= n {
+		return rows
+	}
+	sorted := slices.Clone(rows)
+	slices.SortStableFunc(sorted, func(a, b tableRow) int { return cmp.Compare(b.Cost, a.Cost) })
+	out := make([]tableRow, 0, n+1)
+	var rolled []tableRow
+	for _, r := range sorted {
+		if r.Name != otherName && len(out) < n {
+			out = append(out, r)
+		} else {
+			rolled = append(rolled, r)
+		}
+	}
+	return append(out, rollupRows(rolled))
+}
+
+// rollupRows sums rows into one "__other__" row; its KubernetesPercent is cost-weighted across them.
+func rollupRows(rows []tableRow) tableRow {
+	other := tableRow{Name: otherName}
+	k8sCost := 0.0
+	for _, r := range rows {
+		other.Cost += r.Cost
+		other.CostByType = addCostByType(other.CostByType, r.CostByType)
+		k8sCost += r.Cost * r.KubernetesPercent
+	}
+	if other.Cost != 0 {
+		other.KubernetesPercent = k8sCost / other.Cost
+	}
+	return other
+}
+
+func (e *exporter) filterDaily(aggregate string, byName map[string]float64) map[string]float64 {
+	f := e.filterFor(aggregate)
+	if f.empty() && e.cfg.MinCostThreshold <= 0 {
+		return byName
+	}
+	out := make(map[string]float64, len(byName))
+	for name, v := range byName {
+		keep, rollup := e.keep(f, name, v)
+		if keep {
+			out[name] += v
+		} else if rollup {
+			out[otherName] += v
+		}
+	}
+	return out
+}
+
+// maxErrorBodyBytes caps how much of a non-2xx response body is included in the returned error.
+const maxErrorBodyBytes = 2048
+
+// statusError is returned when OpenCost rejects a request, either with a non-2xx HTTP status or with a
+// non-200 code in the JSON body.
+type statusError struct {
+	endpoint string
+	code     int
+	body     bool   // code came from the JSON body rather than the HTTP status
+	msg      string // truncated response body, if any
+}
+
+func (e *statusError) Error() string {
+	kind := "http status"
+	if e.body {
+		kind = "response code"
+	}
+	if e.msg == "" {
+		return fmt.Sprintf("%s %s %d", e.endpoint, kind, e.code)
+	}
+	return fmt.Sprintf("%s %s %d: %s", e.endpoint, kind, e.code, e.msg)
+}
+
+// httpStatusError builds the error for a non-2xx response, including a truncated copy of the body
+// (which usually carries OpenCost's error message). The rest of the body is drained so the connection can be reused.
+func httpStatusError(endpoint string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes+1))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	msg := strings.TrimSpace(string(body))
+	if len(body) > maxErrorBodyBytes {
+		msg = strings.TrimSpace(string(body[:maxErrorBodyBytes])) + "...(truncated)"
+	}
+	return &statusError{endpoint: endpoint, code: resp.StatusCode, msg: msg}
+}
+
+// httpTiming accumulates the nanoseconds a scrape spends in OpenCost requests, including reading the responses.
+// scrape passes it to the fetchers in the request context under httpTimingKey.
+type httpTiming struct{ atomic.Int64 }
+
+type httpTimingKey struct{}
+
+func addHTTPTime(ctx context.Context, d time.Duration) {
+	if t, ok := ctx.Value(httpTimingKey{}).(*httpTiming); ok {
+		t.Add(int64(d))
+	}
+}
+
+// rateLimiter is a token bucket: it holds up to burst tokens, refilled at rate per second, and each request
+// takes one. It stands in for golang.org/x/time/rate, which isn't a dependency of this module; wait is all the
+// exporter needs, and that is not worth a new module for.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes a token, blocking until it is available or ctx is done, and reports whether it had to block.
+// Tokens may go negative so that concurrent waiters queue up behind each other.
+func (l *rateLimiter) wait(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return false, nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true, nil
+	case <-ctx.Done():
+		// The request is not sent, so give the token back.
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return true, ctx.Err()
+	}
+}
+
+// do sends an OpenCost request, counting the time until the response headers arrive towards the scrape's
+// httpTiming; decodeResponse adds the time reading the body. With REQUESTS_PER_SECOND, it first waits for
+// the rate limiter, which counts as HTTP time too so process_seconds stays the exporter's own work.
+func (e *exporter) do(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	if e.limiter != nil {
+		limited, err := e.limiter.wait(req.Context())
+		if limited {
+			e.rateLimited.Inc()
+		}
+		if err != nil {
+			addHTTPTime(req.Context(), time.Since(start))
+			return nil, err
+		}
+	}
+	resp, err := e.cli.Do(req)
+	addHTTPTime(req.Context(), time.Since(start))
+	return resp, err
+}
+
+// decodeResponse decodes a JSON response body, reading at most MAX_RESPONSE_BYTES of it.
+// The body is read in full before parsing so decodeDuration measures parse time only.
+// A body that is not JSON at all (e.g. an HTML page from a proxy in front of OpenCost) is likely transient,
+// so the request is repeated up to DECODE_RETRIES times; valid JSON of the wrong shape is not retried.
+func (e *exporter) decodeResponse(endpoint string, resp *http.Response, v any) error {
+	// The caller closes resp; every retried response is closed here before the next attempt.
+	b, err := e.readBody(endpoint, resp)
+	for attempt := 1; err == nil && !json.Valid(b) && attempt <= e.cfg.DecodeRetries; attempt++ {
+		e.decodeErrors.WithLabelValues(endpoint).Inc()
+		log.Printf("%s response is not valid JSON, retrying (%d/%d)", endpoint, attempt, e.cfg.DecodeRetries)
+		e.apiCalls.WithLabelValues(endpoint).Inc()
+		var retry *http.Response
+		retry, err = e.do(resp.Request)
+		if err != nil {
+			return err
+		}
+		if retry.StatusCode < 200 || retry.StatusCode > 299 {
+			err = httpStatusError(endpoint, retry)
+			retry.Body.Close()
+			return err
+		}
+		b, err = e.readBody(endpoint, retry)
+		retry.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = json.Unmarshal(b, v)
+	e.decodeDuration.WithLabelValues(endpoint).Set(time.Since(start).Seconds())
+	if err != nil {
+		e.decodeErrors.WithLabelValues(endpoint).Inc()
+		return err
+	}
+	// A null or missing data field would otherwise decode to an empty result and
+	// clear every series; non-200 body codes are left to the caller's code check.
+	var probe struct {
+		Code int             `json:"code"`
+		Data json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(b, &probe) == nil && probe.Code == 200 && (len(probe.Data) == 0 || string(probe.Data) == "null") {
+		return fmt.Errorf("%s response has no data", endpoint)
+	}
+	return nil
+}
+
+// readBody reads resp's body, failing if it is larger than MAX_RESPONSE_BYTES.
+func (e *exporter) readBody(endpoint string, resp *http.Response) ([]byte, error) {
+	readStart := time.Now()
+	// One byte past the limit tells a body of exactly MAX_RESPONSE_BYTES from a larger one.
+	b, err := io.ReadAll(io.LimitReader(resp.Body, e.cfg.MaxResponseBytes+1))
+	addHTTPTime(resp.Request.Context(), time.Since(readStart))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > e.cfg.MaxResponseBytes {
+		return nil, fmt.Errorf("%s response larger than MAX_RESPONSE_BYTES=%d", endpoint, e.cfg.MaxResponseBytes)
+	}
+	return b, nil
+}
+
+// skipCostMetric stops querying costMetric for the life of the process (SKIP_INVALID_COST_METRICS).
+func (e *exporter) skipCostMetric(costMetric string, err error) {
+	log.Printf("cost metric %q rejected by OpenCost, skipping it from now on: %v", costMetric, err)
+	e.invalidCostMetrics[costMetric] = true
+	e.costMetricInvalid.WithLabelValues(costMetric).Set(1)
+}
+
+// namesCostMetric reports whether OpenCost's error body mentions costMetric, i.e. the 400 is about that cost metric.
+func namesCostMetric(err error, costMetric string) bool {
+	var se *statusError
+	return errors.As(err, &se) && strings.Contains(se.msg, costMetric)
+}
+
+// isBadRequest reports whether OpenCost rejected the query itself (HTTP or body code 400), e.g. an unknown costMetric.
+func isBadRequest(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.code == http.StatusBadRequest
+}
+
+// traceConns counts whether the request made with the returned context reused a kept-alive connection.
+func (e *exporter) traceConns(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				e.connReused.Inc()
+			} else {
+				e.connNew.Inc()
+			}
+		},
+	})
+}
+
+// loadClientCert reads the client certificate and key (TLS_CLIENT_CERT_DIR).
+func loadClientCert(dir string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"))
+	if err != nil {
+		return nil, fmt.Errorf("client certificate %s: %w", filepath.Join(dir, "tls.{crt,key}"), err)
+	}
+	return &cert, nil
+}
+
+// verifyConnection records the expiry of the OpenCost server certificate on every TLS handshake and logs its
+// details on the first one (LOG_TLS_CERT_INFO).
+func (e *exporter) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := cs.PeerCertificates[0]
+	e.tlsCertExpiry.Set(float64(cert.NotAfter.Unix()))
+	if e.tlsCertLogged.CompareAndSwap(false, true) {
+		log.Print(e.jobErr(fmt.Errorf("opencost tls certificate: subject=%q issuer=%q not_after=%s", cert.Subject, cert.Issuer, cert.NotAfter.Format(time.RFC3339))))
+	}
+	return nil
+}
+
+func (e *exporter) fetchStatus(ctx context.Context) (cloudCostStatusResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.EndpointTimeouts["status"])
+	defer cancel()
+	req, err := http.NewRequestWithContext(e.traceConns(ctx), http.MethodGet, e.statusURL(), nil)
+	if err != nil {
+		return cloudCostStatusResponse{}, err
+	}
+	e.apiCalls.WithLabelValues("status").Inc()
+	resp, err := e.do(req)
+	if err != nil {
+		return cloudCostStatusResponse{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return cloudCostStatusResponse{}, httpStatusError("status", resp)
+	}
+	var out cloudCostStatusResponse
+	if err := e.decodeResponse("status", resp, &out); err != nil {
+		return cloudCostStatusResponse{}, err
+	}
+	if out.Code != 200 {
+		e.bodyCodeMismatch.WithLabelValues("status").Inc()
+		return cloudCostStatusResponse{}, &statusError{endpoint: "status", code: out.Code, body: true}
+	}
+	e.endpointLastOK.WithLabelValues("status").Set(float64(e.now().Unix()))
+	return out, nil
+}
+
+func (e *exporter) applyStatus(status cloudCostStatusResponse) {
+	now := e.now()
+	keys := make(map[string]bool, len(status.Data))
+	for _, s := range status.Data {
+		// /cloudCost/status has no key or provider parameters, so INTEGRATION_KEY_FILTER and
+		// STATUS_PROVIDER_FILTER are applied here.
+		if len(e.cfg.IntegrationKeys) > 0 && !slices.Contains(e.cfg.IntegrationKeys, s.Key) {
+			continue
+		}
+		if len(e.cfg.StatusProviders) > 0 && !slices.ContainsFunc(e.cfg.StatusProviders, func(p string) bool {
+			return strings.EqualFold(p, s.Provider)
+		}) {
+			continue
+		}
+		if id := s.Provider + "/" + s.Key; !keys[id] {
+			keys[id] = true
+			e.integrations.WithLabelValues(s.Provider).Inc()
+			// Touch the stale series so providers with no stale integrations export 0.
+			stale := e.integrationsStale.WithLabelValues(s.Provider)
+			if t, err := time.Parse(time.RFC3339Nano, s.LastRun); err != nil || now.Sub(t) > e.cfg.IntegrationStaleAfter {
+				stale.Inc()
+			}
+		}
+
+		up := 0.0
+		if s.Active && s.Valid {
+			up = 1.0
+		}
+		e.cloudIntegrationUp.WithLabelValues(s.Key, s.Provider, s.Source, s.ConnectionStatus).Set(up)
+		// Touch the series so a source with every integration down exports 0.
+		if bySource := e.upBySource.WithLabelValues(s.Provider, s.Source); up == 1 {
+			bySource.Set(1)
+		}
+
+		id := s.Provider + "/" + s.Key
+		if !slices.Contains(e.connStatusSeen[id], s.ConnectionStatus) {
+			e.connStatusSeen[id] = append(e.connStatusSeen[id], s.ConnectionStatus)
+		}
+		for _, cs := range e.connStatusSeen[id] {
+			v := 0.0
+			if cs == s.ConnectionStatus {
+				v = 1.0
+			}
+			e.cloudIntegrationCS.WithLabelValues(s.Key, s.Provider, cs).Set(v)
+		}
+
+		stamps := map[string]string{"last_run": s.LastRun, "next_run": s.NextRun, "created": s.Created, "updated": s.Updated}
+		for _, which := range e.cfg.IntegrationTimestamps {
+			// A missing or unparsable timestamp only drops its own series.
+			if t, err := time.Parse(time.RFC3339Nano, stamps[which]); err == nil {
+				e.cloudIntegrationTS.WithLabelValues(s.Key, s.Provider, which).Set(float64(t.Unix()))
+			}
+		}
+		if t, err := time.Parse(time.RFC3339Nano, s.NextRun); err == nil && t.After(now) && (e.nextRun.IsZero() || t.Before(e.nextRun)) {
+			e.nextRun = t
+		}
+	}
+}
+
+func (e *exporter) fetchTotals(ctx context.Context, costMetric string) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.EndpointTimeouts["totals"])
+	defer cancel()
+	req, err := http.NewRequestWithContext(e.traceConns(ctx), http.MethodGet, e.totalsURL(costMetric), nil)
+	if err != nil {
+		return 0, err
+	}
+	e.apiCalls.WithLabelValues("totals").Inc()
+	resp, err := e.do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, httpStatusError("totals", resp)
+	}
+	var out cloudCostTotalsResponse
+	if err := e.decodeResponse("totals", resp, &out); err != nil {
+		return 0, err
+	}
+	if out.Code != 200 {
+		e.bodyCodeMismatch.WithLabelValues("totals").Inc()
+		return 0, &statusError{endpoint: "totals", code: out.Code, body: true}
+	}
+	e.endpointLastOK.WithLabelValues("totals").Set(float64(e.now().Unix()))
+	return e.costFloat("totals", out.Data.Combined.Name, out.Data.Combined.Cost), nil
+}
+
+// costFloat returns the float64 value of a decoded cost. With USE_DECIMAL it logs a warning when the
+// conversion loses more than DECIMAL_WARN_THRESHOLD compared to the exact decimal OpenCost returned.
+func (e *exporter) costFloat(endpoint, name string, c costValue) float64 {
+	if !e.cfg.UseDecimal {
+		return c.f
+	}
+	exact := c.rat()
+	if exact == nil {
+		return c.f
+	}
+	loss := new(big.Rat).Sub(exact, new(big.Rat).SetFloat64(c.f))
+	if lossF, _ := loss.Abs(loss).Float64(); lossF > e.cfg.DecimalWarnThreshold {
+		log.Printf("warning: %s cost for %q loses %g converting %s to float64", endpoint, name, lossF, c.raw)
+	}
+	return c.f
+}
+
+type tableRow struct {
+	Name              string
+	KubernetesPercent float64
+	Cost              float64
+	// CostByType maps cost types (e.g. listCost) to their cost, for the types OpenCost included in the row.
+	CostByType map[string]float64
+}
+
+// fetchTable also returns how many rows OpenCost sent (before duplicate names are merged). The response carries
+// no total row count, only the (limited) rows, so that count is all there is to compare against tableLimit.
+func (e *exporter) fetchTable(ctx context.Context, aggregate, costMetric string) ([]tableRow, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.EndpointTimeouts["table"])
+	defer cancel()
+	req, err := http.NewRequestWithContext(e.traceConns(ctx), http.MethodGet, e.tableURL(aggregate, costMetric), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	e.apiCalls.WithLabelValues("table").Inc()
+	resp, err := e.do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, 0, httpStatusError("table", resp)
+	}
+	var out cloudCostTableResponse
+	if err := e.decodeResponse("table", resp, &out); err != nil {
+		return nil, 0, err
+	}
+	if out.Code != 200 {
+		e.bodyCodeMismatch.WithLabelValues("table").Inc()
+		return nil, 0, &statusError{endpoint: "table", code: out.Code, body: true}
+	}
+	e.endpointLastOK.WithLabelValues("table").Set(float64(e.now().Unix()))
+	rows := make([]tableRow, 0, len(out.Data))
+	for _, r := range out.Data {
+		row := tableRow{Name: e.normalizeName(aggregate, r.Name), KubernetesPercent: r.KubernetesPercent.f, Cost: e.costFloat("table", r.Name, r.Cost)}
+		if e.cfg.TableCostBreakdown {
+			for costType, c := range map[string]*costValue{
+				"listCost":         r.ListCost,
+				"netCost":          r.NetCost,
+				"amortizedNetCost": r.AmortizedNetCost,
+				"amortizedCost":    r.AmortizedCost,
+				"invoicedCost":     r.InvoicedCost,
+			} {
+				if c == nil {
+					continue
+				}
+				if row.CostByType == nil {
+					row.CostByType = map[string]float64{}
+				}
+				row.CostByType[costType] = e.costFloat("table", r.Name, *c)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return mergeDuplicateRows(rows), len(out.Data), nil
+}
+
+// normalizeName lowercases category names with NORMALIZE_CATEGORY, so "Compute" and "compute" from different
+// providers become one series. It is applied to both table and graph results so they join.
+func (e *exporter) normalizeName(aggregate, name string) string {
+	if aggregate == "category" && e.cfg.NormalizeCategory {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// addCostByType adds the costs in src to dst, allocating dst if needed, and returns it.
+func addCostByType(dst, src map[string]float64) map[string]float64 {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]float64, len(src))
+	}
+	for costType, v := range src {
+		dst[costType] += v
+	}
+	return dst
+}
+
+// labelHashSuffixLen is the length of the "~" and hex hash truncateLabelValue appends.
+const labelHashSuffixLen = 17
+
+// truncateLabelValue shortens v to at most max bytes (0 leaves it as is), ending it with "~" and a hash of the whole
+// value so different long values stay distinct series.
+func truncateLabelValue(v string, max int) string {
+	if max <= 0 || len(v) <= max {
+		return v
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(v))
+	n := max - labelHashSuffixLen
+	// Don't cut a multi-byte character in half; label values must be valid UTF-8.
+	for n > 0 && !utf8.RuneStart(v[n]) {
+		n--
+	}
+	return fmt.Sprintf("%s~%016x", v[:n], h.Sum64())
+}
+
+// mergeDuplicateRows sums rows sharing a name (seen with some "item" names), since setting the same series twice
+// would keep only the last cost. KubernetesPercent is cost-weighted. The first occurrence keeps its position.
+func mergeDuplicateRows(rows []tableRow) []tableRow {
+	idx := make(map[string]int, len(rows))
+	k8sCost := make([]float64, 0, len(rows))
+	out := make([]tableRow, 0, len(rows))
+	for _, r := range rows {
+		i, ok := idx[r.Name]
+		if !ok {
+			idx[r.Name] = len(out)
+			out = append(out, r)
+			k8sCost = append(k8sCost, r.Cost*r.KubernetesPercent)
+			continue
+		}
+		out[i].Cost += r.Cost
+		out[i].CostByType = addCostByType(out[i].CostByType, r.CostByType)
+		k8sCost[i] += r.Cost * r.KubernetesPercent
+		if out[i].Cost != 0 {
+			out[i].KubernetesPercent = k8sCost[i] / out[i].Cost
+		}
+	}
+	return out
+}
+
+type dailyPoint struct {
+	Day       string
+	Total     float64
+	ByService map[string]float64
+}
+
+// itemFields are the parts of "item" names, in order; providerID may itself contain slashes.
+var itemFields = []string{"invoiceEntityID", "accountID", "provider", "providerID", "category", "service"}
+
+// splitItem splits an "item" name into its itemFields parts. ok is false if the name has fewer parts.
+func splitItem(item string) (parts []string, ok bool) {
+	parts = strings.Split(item, "/")
+	if len(parts) < len(itemFields) {
+		return nil, false
+	}
+	n := len(parts)
+	return []string{parts[0], parts[1], parts[2], strings.Join(parts[3:n-2], "/"), parts[n-2], parts[n-1]}, true
+}
+
+// dailyFor returns the daily series of aggregate for the scrape: rolled up from the item graph when items were
+// fetched (GRAPH_DERIVE_FROM_ITEM) and the aggregate can be derived from them, otherwise from its own graph call.
+func (e *exporter) dailyFor(ctx context.Context, report *lastScrapeReport, items []dailyPoint, aggregate, costMetric string) ([]dailyPoint, error) {
+	if items != nil {
+		if aggregate == "item" {
+			return items, nil
+		}
+		if points, ok := e.rollupItems(items, aggregate); ok {
+			return points, nil
+		}
+	}
+	points, err := e.fetchGraph(ctx, e.dailyQueryWindow, aggregate, costMetric)
+	report.request("graph", e.graphURL(e.dailyQueryWindow, aggregate, costMetric), err)
+	return points, err
+}
+
+// rollupItems sums item graph points by the part of their names that is aggregate. ok is false if aggregate is
+// not one of itemFields or a name doesn't have all of them.
+func (e *exporter) rollupItems(items []dailyPoint, aggregate string) (points []dailyPoint, ok bool) {
+	field := slices.Index(itemFields, aggregate)
+	if field < 0 {
+		return nil, false
+	}
+	points = make([]dailyPoint, 0, len(items))
+	for _, p := range items {
+		byName := make(map[string]float64)
+		for item, v := range p.ByService {
+			parts, ok := splitItem(item)
+			if !ok {
+				return nil, false
+			}
+			byName[e.normalizeName(aggregate, parts[field])] += v
+		}
+		points = append(points, dailyPoint{Day: p.Day, Total: p.Total, ByService: byName})
+	}
+	return points, true
+}
+
+func (e *exporter) fetchGraph(ctx context.Context, window, aggregate, costMetric string) ([]dailyPoint, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.EndpointTimeouts["graph"])
+	defer cancel()
+	req, err := http.NewRequestWithContext(e.traceConns(ctx), http.MethodGet, e.graphURL(window, aggregate, costMetric), nil)
+	if err != nil {
+		return nil, err
+	}
+	e.apiCalls.WithLabelValues("graph").Inc()
+	resp, err := e.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, httpStatusError("graph", resp)
+	}
+	var out cloudCostGraphResponse
+	if err := e.decodeResponse("graph", resp, &out); err != nil {
+		return nil, err
+	}
+	if out.Code != 200 {
+		e.bodyCodeMismatch.WithLabelValues("graph").Inc()
+		return nil, &statusError{endpoint: "graph", code: out.Code, body: true}
+	}
+	e.endpointLastOK.WithLabelValues("graph").Set(float64(e.now().Unix()))
+
+	// Entries are folded by day: with sub-day windows (e.g. 10m while testing against a mock) or sub-day
+	// steps OpenCost can return several entries for the same day, which would otherwise export duplicate samples.
+	points := make([]dailyPoint, 0, len(out.Data))
+	dayIndex := make(map[string]int, len(out.Data))
+	for _, d := range out.Data {
+		// OpenCost returns start like "2025-12-04T00:00:00Z"; the day is its UTC date. DAY_TIMEZONE only moves the
+		// sample timestamp: converting the start would put every day west of UTC on the previous date.
+		day := d.Start
+		if t, err := time.Parse(time.RFC3339, d.Start); err == nil {
+			day = t.UTC().Format("2006-01-02")
+		} else if len(day) >= 10 {
+			day = day[:10]
+		}
+		byService := make(map[string]float64, len(d.Items))
+		total := 0.0
+		exactTotal := new(big.Rat)
+		for _, it := range d.Items {
+			v := e.costFloat("graph", it.Name, it.Value)
+			byService[e.normalizeName(aggregate, it.Name)] += v
+			total += v
+			if r := it.Value.rat(); r != nil {
+				exactTotal.Add(exactTotal, r)
+			}
+		}
+		if e.cfg.UseDecimal {
+			// Sum exactly and round once, rather than accumulating float64 rounding errors.
+			total, _ = exactTotal.Float64()
+		}
+		if i, ok := dayIndex[day]; ok {
+			points[i].Total += total
+			for name, v := range byService {
+				points[i].ByService[name] += v
+			}
+			continue
+		}
+		dayIndex[day] = len(points)
+		points = append(points, dailyPoint{
+			Day:       day,
+			Total:     total,
+			ByService: byService,
+		})
+	}
+	return points, nil
+}
+
+type dailySample struct {
+	desc   *prometheus.Desc
+	labels []string
+	value  float64
+	ts     time.Time
+}
+
+type dailyCollector struct {
+	mu sync.Mutex
+
+	dailyAggCostDesc      *prometheus.Desc
+	dailyServiceCostDesc  *prometheus.Desc
+	dailyTotalCostDesc    *prometheus.Desc
+	dailyCategoryCostDesc *prometheus.Desc
 
-	// Reset only the series for this window/metric by wiping all and rebuilding.
-	// This exporter is intended to run with a single configured window, but may scrape multiple aggregates/cost metrics.
-	e.cloudIntegrationUp.Reset()
-	e.cloudIntegrationTS.Reset()
-	e.cloudAggCost.Reset()
-	e.cloudAggK8sPct.Reset()
-	e.cloudServiceCost.Reset()
-	e.cloudServiceK8sPct.Reset()
-	e.cloudCategoryCost.Reset()
-	e.daily.Reset()
+	// loc is the timezone days are parsed in (DAY_TIMEZONE).
+	loc *time.Location
 
-	status, err := e.fetchStatus(ctx)
-	if err != nil {
-		e.scrapeSuccess.Set(0)
-		return err
+	// maxNameLen truncates name label values (MAX_LABEL_VALUE_LEN).
+	maxNameLen int
+
+	// Samples timestamped before minTS (zero keeps all) are not added but counted in dropped.
+	// Both are only touched by scrape.
+	minTS   time.Time
+	dropped int
+
+	// backfilled holds the samples of the last /backfill request; Reset keeps them.
+	backfilled []dailySample
+
+	samples []dailySample
+}
+
+// dailyMetricNames are the families exported by dailyCollector.
+var dailyMetricNames = []string{
+	"opencost_cloudcost_daily_aggregate_cost",
+	"opencost_cloudcost_daily_service_cost",
+	"opencost_cloudcost_daily_total_cost",
+	"opencost_cloudcost_daily_category_cost",
+}
+
+func newDailyCollector(costLabels prometheus.Labels, disabled map[string]bool, loc *time.Location, maxNameLen int) *dailyCollector {
+	// Disabled families get a nil desc: they are neither described nor sampled.
+	newDesc := func(name, help string, labels []string) *prometheus.Desc {
+		if disabled[name] {
+			return nil
+		}
+		return prometheus.NewDesc(name, help, labels, costLabels)
+	}
+	return &dailyCollector{
+		loc:        loc,
+		maxNameLen: maxNameLen,
+		dailyAggCostDesc: newDesc(
+			"opencost_cloudcost_daily_aggregate_cost",
+			"Cloud cost by aggregate property per day (from /cloudCost/view/graph).",
+			[]string{"aggregate", "name", "day", "window", "cost_metric"},
+		),
+		dailyServiceCostDesc: newDesc(
+			"opencost_cloudcost_daily_service_cost",
+			"Cloud cost by service per day (from /cloudCost/view/graph).",
+			[]string{"service", "day", "window", "cost_metric"},
+		),
+		dailyTotalCostDesc: newDesc(
+			"opencost_cloudcost_daily_total_cost",
+			"Total cloud cost per day (sum of items in /cloudCost/view/graph).",
+			[]string{"day", "window", "cost_metric"},
+		),
+		dailyCategoryCostDesc: newDesc(
+			"opencost_cloudcost_daily_category_cost",
+			"Cloud cost by category (resource type) per day (from /cloudCost/view/graph).",
+			[]string{"category", "day", "window", "cost_metric"},
+		),
 	}
-	e.applyStatus(status)
+}
 
-	for _, costMetric := range e.cfg.CostMetrics {
-		totals, err := e.fetchTotals(ctx, costMetric)
-		if err != nil {
-			e.scrapeSuccess.Set(0)
-			return err
+func (d *dailyCollector) names() []string {
+	return dailyMetricNames
+}
+
+func (d *dailyCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{d.dailyAggCostDesc, d.dailyServiceCostDesc, d.dailyTotalCostDesc, d.dailyCategoryCostDesc} {
+		if desc != nil {
+			ch <- desc
 		}
-		e.cloudTotalCost.WithLabelValues(e.cfg.Window, costMetric).Set(totals)
+	}
+}
+
+func (d *dailyCollector) Collect(ch chan<- prometheus.Metric) {
+	d.mu.Lock()
+	snaps := make([]dailySample, 0, len(d.samples)+len(d.backfilled))
+	snaps = append(snaps, d.samples...)
+	snaps = append(snaps, d.backfilled...)
+	d.mu.Unlock()
 
-		// Always scrape daily totals from service graph (used by dashboards, and gives a consistent total).
-		dailyService, err := e.fetchGraph(ctx, "service", costMetric)
+	for _, s := range snaps {
+		m, err := prometheus.NewConstMetric(s.desc, prometheus.GaugeValue, s.value, s.labels...)
 		if err != nil {
-			e.scrapeSuccess.Set(0)
-			return err
-		}
-		for _, d := range dailyService {
-			day := d.Day
-			if err := e.daily.SetTotalCost(day, e.cfg.Window, costMetric, d.Total); err != nil {
-				e.scrapeSuccess.Set(0)
-				return err
-			}
-			for svc, v := range d.ByService {
-				if err := e.daily.SetAggCost("service", svc, day, e.cfg.Window, costMetric, v); err != nil {
-					e.scrapeSuccess.Set(0)
-					return err
-				}
-				if err := e.daily.SetServiceCost(svc, day, e.cfg.Window, costMetric, v); err != nil {
-					e.scrapeSuccess.Set(0)
-					return err
-				}
-			}
+			log.Printf("daily metric build failed: %v", err)
+			continue
 		}
+		ch <- prometheus.NewMetricWithTimestamp(s.ts, m)
+	}
+}
 
-		for _, agg := range e.cfg.Aggregates {
-			rows, err := e.fetchTable(ctx, agg, costMetric)
-			if err != nil {
-				e.scrapeSuccess.Set(0)
-				return err
-			}
-			for _, r := range rows {
-				e.cloudAggCost.WithLabelValues(agg, r.Name, e.cfg.Window, costMetric).Set(r.Cost)
-				e.cloudAggK8sPct.WithLabelValues(agg, r.Name, e.cfg.Window, costMetric).Set(r.KubernetesPercent)
+// scratch returns an empty collector with d's descs and timezone, for building samples outside of scrape.
+func (d *dailyCollector) scratch() *dailyCollector {
+	return &dailyCollector{
+		dailyAggCostDesc:      d.dailyAggCostDesc,
+		dailyServiceCostDesc:  d.dailyServiceCostDesc,
+		dailyTotalCostDesc:    d.dailyTotalCostDesc,
+		dailyCategoryCostDesc: d.dailyCategoryCostDesc,
+		loc:                   d.loc,
+		maxNameLen:            d.maxNameLen,
+	}
+}
 
-				if agg == "service" {
-					e.cloudServiceCost.WithLabelValues(r.Name, e.cfg.Window, costMetric).Set(r.Cost)
-					e.cloudServiceK8sPct.WithLabelValues(r.Name, e.cfg.Window, costMetric).Set(r.KubernetesPercent)
-				}
-				if agg == "category" {
-					e.cloudCategoryCost.WithLabelValues(r.Name, e.cfg.Window, costMetric).Set(r.Cost)
-				}
-			}
+// SetBackfilled replaces the backfilled samples with those of src.
+func (d *dailyCollector) SetBackfilled(src *dailyCollector) {
+	src.mu.Lock()
+	samples := slices.Clone(src.samples)
+	src.mu.Unlock()
+	d.mu.Lock()
+	d.backfilled = samples
+	d.mu.Unlock()
+}
 
-			// Daily series for each aggregate (service already scraped above).
-			if agg == "service" {
-				continue
-			}
-			daily, err := e.fetchGraph(ctx, agg, costMetric)
-			if err != nil {
-				e.scrapeSuccess.Set(0)
-				return err
-			}
-			for _, d := range daily {
-				day := d.Day
-				for name, v := range d.ByService {
-					if err := e.daily.SetAggCost(agg, name, day, e.cfg.Window, costMetric, v); err != nil {
-						e.scrapeSuccess.Set(0)
-						return err
-					}
-					if agg == "category" {
-						if err := e.daily.SetCategoryCost(name, day, e.cfg.Window, costMetric, v); err != nil {
-							e.scrapeSuccess.Set(0)
-							return err
-						}
-					}
-				}
-			}
+// backfilledSeries returns how many backfilled samples each family exports.
+func (d *dailyCollector) backfilledSeries() map[string]int {
+	names := map[*prometheus.Desc]string{}
+	for i, desc := range []*prometheus.Desc{d.dailyAggCostDesc, d.dailyServiceCostDesc, d.dailyTotalCostDesc, d.dailyCategoryCostDesc} {
+		if desc != nil {
+			names[desc] = dailyMetricNames[i]
 		}
 	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	counts := map[string]int{}
+	for _, s := range d.backfilled {
+		counts[names[s.desc]]++
+	}
+	return counts
+}
 
-	e.scrapeSuccess.Set(1)
+func (d *dailyCollector) Reset() {
+	d.mu.Lock()
+	d.samples = d.samples[:0]
+	d.dropped = 0
+	d.mu.Unlock()
+}
+
+func parseDay(day string, loc *time.Location) (time.Time, error) {
+	// day is expected to be YYYY-MM-DD (derived from OpenCost graph start).
+	t, err := time.ParseInLocation("2006-01-02", day, loc)
+	if err != nil {
+		return t, err
+	}
+	// Where DST starts at midnight (e.g. America/Santiago), that midnight doesn't exist and is normalized into the
+	// previous day; the day then starts when the new offset takes effect, at the end of the zone period t is in.
+	// 23 and 25 hour days with transitions later in the day need nothing special: their midnight is unambiguous.
+	if t.Format("2006-01-02") != day {
+		_, t = t.ZoneBounds()
+	}
+	return t, nil
+}
+
+func (d *dailyCollector) add(desc *prometheus.Desc, ts time.Time, value float64, labels ...string) {
+	if desc == nil {
+		return
+	}
+	if ts.Before(d.minTS) {
+		d.dropped++
+		return
+	}
+	d.samples = append(d.samples, dailySample{
+		desc:   desc,
+		labels: append([]string(nil), labels...),
+		value:  value,
+		ts:     ts,
+	})
+}
+
+func (d *dailyCollector) SetAggCost(aggregate, name, day, window, costMetric string, value float64) error {
+	ts, err := parseDay(day, d.loc)
+	if err != nil {
+		return fmt.Errorf("invalid day %q for daily_aggregate_cost: %w", day, err)
+	}
+	d.mu.Lock()
+	d.add(d.dailyAggCostDesc, ts, value, aggregate, truncateLabelValue(name, d.maxNameLen), day, window, costMetric)
+	d.mu.Unlock()
 	return nil
 }
 
-func (e *exporter) fetchStatus(ctx context.Context) (cloudCostStatusResponse, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.statusURL(), nil)
+func (d *dailyCollector) SetServiceCost(service, day, window, costMetric string, value float64) error {
+	ts, err := parseDay(day, d.loc)
 	if err != nil {
-		return cloudCostStatusResponse{}, err
+		return fmt.Errorf("invalid day %q for daily_service_cost: %w", day, err)
 	}
-	resp, err := e.cli.Do(req)
+	d.mu.Lock()
+	d.add(d.dailyServiceCostDesc, ts, value, truncateLabelValue(service, d.maxNameLen), day, window, costMetric)
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *dailyCollector) SetTotalCost(day, window, costMetric string, value float64) error {
+	ts, err := parseDay(day, d.loc)
 	if err != nil {
-		return cloudCostStatusResponse{}, err
+		return fmt.Errorf("invalid day %q for daily_total_cost: %w", day, err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return cloudCostStatusResponse{}, fmt.Errorf("status http status %d", resp.StatusCode)
+	d.mu.Lock()
+	d.add(d.dailyTotalCostDesc, ts, value, day, window, costMetric)
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *dailyCollector) SetCategoryCost(category, day, window, costMetric string, value float64) error {
+	ts, err := parseDay(day, d.loc)
+	if err != nil {
+		return fmt.Errorf("invalid day %q for daily_category_cost: %w", day, err)
 	}
-	var out cloudCostStatusResponse
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return cloudCostStatusResponse{}, err
+	d.mu.Lock()
+	d.add(d.dailyCategoryCostDesc, ts, value, truncateLabelValue(category, d.maxNameLen), day, window, costMetric)
+	d.mu.Unlock()
+	return nil
+}
+
+// timestampedCollector exports the metrics of the wrapped collector with the timestamp ts returns (unix nanos),
+// or without one while it is 0.
+type timestampedCollector struct {
+	prometheus.Collector
+	ts func() int64
+}
+
+func (t timestampedCollector) Collect(ch chan<- prometheus.Metric) {
+	ts := t.ts()
+	if ts == 0 {
+		t.Collector.Collect(ch)
+		return
 	}
-	if out.Code != 200 {
-		return cloudCostStatusResponse{}, fmt.Errorf("status response code %d", out.Code)
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		t.Collector.Collect(metrics)
+		close(metrics)
+	}()
+	for m := range metrics {
+		ch <- prometheus.NewMetricWithTimestamp(time.Unix(0, ts), m)
 	}
-	return out, nil
 }
 
-func (e *exporter) applyStatus(status cloudCostStatusResponse) {
-	for _, s := range status.Data {
-		up := 0.0
-		if s.Active && s.Valid {
-			up = 1.0
-		}
-		e.cloudIntegrationUp.WithLabelValues(s.Key, s.Provider, s.Source, s.ConnectionStatus).Set(up)
+const (
+	// openCostHealthTimeout bounds the OpenCost check behind /readyz; results are reused for openCostHealthTTL.
+	openCostHealthTimeout = 5 * time.Second
+	openCostHealthTTL     = 15 * time.Second
+)
+
+// checkOpenCost fetches /cloudCost/status to tell whether OpenCost is reachable. The result is cached briefly so
+// frequent probes don't hammer OpenCost; concurrent callers wait for a single in-flight check.
+func (e *exporter) checkOpenCost(ctx context.Context) error {
+	e.openCostHealth.mu.Lock()
+	defer e.openCostHealth.mu.Unlock()
+	if !e.openCostHealth.checked.IsZero() && e.now().Sub(e.openCostHealth.checked) < openCostHealthTTL {
+		return e.openCostHealth.err
+	}
+	ctx, cancel := context.WithTimeout(ctx, openCostHealthTimeout)
+	defer cancel()
+	_, err := e.fetchStatus(ctx)
+	e.openCostHealth.checked = e.now()
+	e.openCostHealth.err = err
+	return err
+}
+
+// scoped runs a one-off scrape of a validated window and/or costMetric (empty keeps the configured value) on a fresh exporter
+// with its own registry, for the multi-target /metrics?window=...&cost_metric=... pattern. The background
+// exporter's state is not touched; only the HTTP client is shared.
+func (e *exporter) scoped(ctx context.Context, window, costMetric string) (*prometheus.Registry, error) {
+	cfg := e.cfg
+	if window != "" {
+		cfg.Window, cfg.DailyWindow, cfg.WindowRelative = window, window, ""
+	}
+	if costMetric != "" {
+		cfg.CostMetric, cfg.CostMetrics = costMetric, []string{costMetric}
+	}
+	registry := prometheus.NewRegistry()
+	s := newExporter(cfg, registry, e.job)
+	s.cli = e.cli
+	s.limiter = e.limiter
+	return registry, s.scrape(ctx)
+}
 
-		if t, err := time.Parse(time.RFC3339Nano, s.LastRun); err == nil {
-			e.cloudIntegrationTS.WithLabelValues(s.Key, s.Provider, "last_run").Set(float64(t.Unix()))
+// backfill fetches the daily series of window/costMetric and exports them next to the regular ones, replacing
+// the previous backfill. It only uses the graph endpoint and does not touch the state of scrape. Like the
+// regular daily series, samples older than DAILY_MAX_BACKFILL are dropped, since Prometheus would reject them too.
+func (e *exporter) backfill(ctx context.Context, window, costMetric string) (int, error) {
+	d := e.daily.scratch()
+	if e.cfg.DailyMaxBackfill > 0 {
+		d.minTS = e.now().Add(-e.cfg.DailyMaxBackfill)
+	}
+	var aggs []string
+	if e.cfg.scrapesAggregate(e.cfg.DailyTotalAggregate, costMetric) {
+		aggs = append(aggs, e.cfg.DailyTotalAggregate)
+	}
+	for _, agg := range e.cfg.Aggregates {
+		if agg != e.cfg.DailyTotalAggregate && e.cfg.scrapesAggregate(agg, costMetric) {
+			aggs = append(aggs, agg)
+		}
+	}
+	for _, agg := range aggs {
+		points, err := e.fetchGraph(ctx, window, agg, costMetric)
+		if err != nil {
+			return 0, err
 		}
-		if t, err := time.Parse(time.RFC3339Nano, s.NextRun); err == nil {
-			e.cloudIntegrationTS.WithLabelValues(s.Key, s.Provider, "next_run").Set(float64(t.Unix()))
+		for _, p := range points {
+			if agg == e.cfg.DailyTotalAggregate {
+				if err := d.SetTotalCost(p.Day, window, costMetric, p.Total); err != nil {
+					return 0, err
+				}
+			}
+			for name, v := range e.filterDaily(agg, p.ByService) {
+				if err := d.SetAggCost(agg, name, p.Day, window, costMetric, v); err != nil {
+					return 0, err
+				}
+				if agg == "service" {
+					if err := d.SetServiceCost(name, p.Day, window, costMetric, v); err != nil {
+						return 0, err
+					}
+				}
+				if agg == "category" {
+					if err := d.SetCategoryCost(name, p.Day, window, costMetric, v); err != nil {
+						return 0, err
+					}
+				}
+			}
 		}
 	}
+	if d.dropped > 0 {
+		log.Printf("backfill dropped %d daily samples older than DAILY_MAX_BACKFILL=%s", d.dropped, e.cfg.DailyMaxBackfill)
+	}
+	e.daily.SetBackfilled(d)
+	return len(d.samples), nil
 }
 
-func (e *exporter) fetchTotals(ctx context.Context, costMetric string) (float64, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.totalsURL(costMetric), nil)
-	if err != nil {
-		return 0, err
+// breakerInterval returns the refresh interval after failures consecutive failed scrapes: interval until
+// threshold (0 disables) is reached, then doubling with every failure up to maxInterval.
+func breakerInterval(interval, maxInterval time.Duration, threshold, failures int) time.Duration {
+	if threshold <= 0 || failures < threshold {
+		return interval
 	}
-	resp, err := e.cli.Do(req)
-	if err != nil {
-		return 0, err
+	d := interval
+	for i := threshold; i <= failures && d < maxInterval; i++ {
+		d *= 2
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return 0, fmt.Errorf("totals http status %d", resp.StatusCode)
+	return min(d, maxInterval)
+}
+
+// jitteredInterval returns interval shifted by a uniformly random offset in [-jitter, +jitter].
+// randN must return a value in [0, n), e.g. rand.Int64N.
+func jitteredInterval(interval, jitter time.Duration, randN func(n int64) int64) time.Duration {
+	if jitter <= 0 {
+		return interval
 	}
-	var out cloudCostTotalsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return 0, err
+	return interval - jitter + time.Duration(randN(2*int64(jitter)+1))
+}
+
+// scrapeStuck reports whether the scrape in progress has been running longer than SCRAPE_WATCHDOG_TIMEOUT.
+func (e *exporter) scrapeStuck() (time.Duration, bool) {
+	started := e.scrapeStartedAt.Load()
+	if e.cfg.ScrapeWatchdogTimeout <= 0 || started == 0 {
+		return 0, false
 	}
-	if out.Code != 200 {
-		return 0, fmt.Errorf("totals response code %d", out.Code)
+	running := time.Since(time.Unix(0, started))
+	return running, running > e.cfg.ScrapeWatchdogTimeout
+}
+
+// watchdog exits the process when a scrape hangs, so Kubernetes restarts the pod.
+func (e *exporter) watchdog() {
+	t := time.NewTicker(e.cfg.ScrapeWatchdogTimeout / 4)
+	defer t.Stop()
+	for range t.C {
+		if running, stuck := e.scrapeStuck(); stuck {
+			log.Fatalf("watchdog: scrape running for %s (SCRAPE_WATCHDOG_TIMEOUT=%s), exiting", running, e.cfg.ScrapeWatchdogTimeout)
+		}
 	}
-	return out.Data.Combined.Cost, nil
 }
 
-type tableRow struct {
-	Name              string
-	KubernetesPercent float64
-	Cost              float64
+// OTLP/HTTP JSON encoding of the metrics pushed with OTEL_METRICS_ENABLED. Only the fields the exporter sets are
+// modelled; 64-bit integers are strings, as required by the protobuf JSON mapping.
+// This replaces the OpenTelemetry metrics SDK on purpose: every value is already in the Prometheus registry, with
+// per-day timestamps the SDK's observable gauges cannot carry, and the SDK with its OTLP exporter would add more
+// dependencies than the rest of the exporter has.
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpDataPoint struct {
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	TimeUnixNano string         `json:"timeUnixNano"`
+	AsDouble     float64        `json:"asDouble"`
+}
+
+type otlpMetric struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Gauge       struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	} `json:"gauge"`
+}
+
+type otlpScopeMetrics struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
 }
 
-func (e *exporter) fetchTable(ctx context.Context, aggregate, costMetric string) ([]tableRow, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.tableURL(aggregate, costMetric), nil)
+func otlpAttr(key, value string) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	kv.Value.StringValue = value
+	return kv
+}
+
+// otlpMetrics converts the opencost_cloudcost_* gauge families of a registry gather into OTLP gauges. Samples without a timestamp
+// are stamped with now; daily samples keep their per-day timestamps. NaN/Inf values have no JSON encoding and
+// are skipped.
+func otlpMetrics(mfs []*dto.MetricFamily, now time.Time) []otlpMetric {
+	var out []otlpMetric
+	for _, mf := range mfs {
+		// Runtime and process metrics stay Prometheus-only.
+		if mf.GetType() != dto.MetricType_GAUGE || !strings.HasPrefix(mf.GetName(), "opencost_cloudcost_") {
+			continue
+		}
+		m := otlpMetric{Name: mf.GetName(), Description: mf.GetHelp()}
+		for _, s := range mf.GetMetric() {
+			v := s.GetGauge().GetValue()
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			ts := now
+			if s.TimestampMs != nil {
+				ts = time.UnixMilli(s.GetTimestampMs())
+			}
+			dp := otlpDataPoint{TimeUnixNano: strconv.FormatInt(ts.UnixNano(), 10), AsDouble: v}
+			for _, l := range s.GetLabel() {
+				dp.Attributes = append(dp.Attributes, otlpAttr(l.GetName(), l.GetValue()))
+			}
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, dp)
+		}
+		if len(m.Gauge.DataPoints) > 0 {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// pushOTLP sends the current gauges to the OTLP/HTTP collector at OTEL_EXPORTER_OTLP_ENDPOINT.
+func (e *exporter) pushOTLP(ctx context.Context, cli *http.Client) error {
+	mfs, err := e.reg.Gather()
+	if err != nil {
+		return err
+	}
+	var sm otlpScopeMetrics
+	sm.Scope.Name = "opencost-cloud-costs-exporter"
+	sm.Metrics = otlpMetrics(mfs, e.now())
+	var pushed map[string]float64
+	if e.cfg.PushOnlyChanged {
+		sm.Metrics, pushed = onlyChanged(sm.Metrics, e.otlpPushed)
+		if len(sm.Metrics) == 0 {
+			return nil
+		}
+	}
+	var rm otlpResourceMetrics
+	rm.Resource.Attributes = []otlpKeyValue{otlpAttr("service.name", "opencost-cloud-costs-exporter")}
+	rm.ScopeMetrics = []otlpScopeMetrics{sm}
+	b, err := json.Marshal(otlpRequest{ResourceMetrics: []otlpResourceMetrics{rm}})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	resp, err := e.cli.Do(req)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.OTelEndpoint+"/v1/metrics", bytes.NewReader(b))
 	if err != nil {
-		return nil, err
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := cli.Do(req)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return nil, fmt.Errorf("table http status %d", resp.StatusCode)
-	}
-	var out cloudCostTableResponse
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return nil, err
+		return httpStatusError("otlp", resp)
 	}
-	if out.Code != 200 {
-		return nil, fmt.Errorf("table response code %d", out.Code)
+	if pushed != nil {
+		e.otlpPushed = pushed
 	}
-	rows := make([]tableRow, 0, len(out.Data))
-	for _, r := range out.Data {
-		rows = append(rows, tableRow{Name: r.Name, KubernetesPercent: r.KubernetesPercent, Cost: r.Cost})
+	return nil
+}
+
+// otlpPointKey identifies a data point by metric name and attributes (sorted, as gathered).
+func otlpPointKey(name string, dp otlpDataPoint) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, a := range dp.Attributes {
+		b.WriteString("\xff" + a.Key + "=" + a.Value.StringValue)
 	}
-	return rows, nil
+	return b.String()
 }
 
-type dailyPoint struct {
-	Day       string
-	Total     float64
-	ByService map[string]float64
+// onlyChanged drops the data points whose value equals the one in prev, and returns the remaining metrics along
+// with the values of all data points, to compare the next push against.
+func onlyChanged(metrics []otlpMetric, prev map[string]float64) ([]otlpMetric, map[string]float64) {
+	current := map[string]float64{}
+	var out []otlpMetric
+	for _, m := range metrics {
+		points := m.Gauge.DataPoints
+		m.Gauge.DataPoints = nil
+		for _, dp := range points {
+			key := otlpPointKey(m.Name, dp)
+			current[key] = dp.AsDouble
+			if v, ok := prev[key]; ok && v == dp.AsDouble {
+				continue
+			}
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, dp)
+		}
+		if len(m.Gauge.DataPoints) > 0 {
+			out = append(out, m)
+		}
+	}
+	return out, current
 }
 
-func (e *exporter) fetchGraph(ctx context.Context, aggregate, costMetric string) ([]dailyPoint, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.graphURL(aggregate, costMetric), nil)
+// writeMetricsFile gathers the registry and writes it in OpenMetrics text format. The file is written to a
+// temporary path first and renamed, so readers never see a partial export.
+func (e *exporter) writeMetricsFile(path string) error {
+	mfs, err := e.reg.Gather()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	resp, err := e.cli.Do(req)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return nil, fmt.Errorf("graph http status %d", resp.StatusCode)
+	enc := expfmt.NewEncoder(f, expfmt.NewFormat(expfmt.TypeOpenMetrics))
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			f.Close()
+			return err
+		}
 	}
-	var out cloudCostGraphResponse
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return nil, err
+	if c, ok := enc.(expfmt.Closer); ok {
+		if err := c.Close(); err != nil {
+			f.Close()
+			return err
+		}
 	}
-	if out.Code != 200 {
-		return nil, fmt.Errorf("graph response code %d", out.Code)
+	if err := f.Close(); err != nil {
+		return err
 	}
+	return os.Rename(tmp, path)
+}
 
-	points := make([]dailyPoint, 0, len(out.Data))
-	for _, d := range out.Data {
-		// OpenCost returns start like "2025-12-04T00:00:00Z"
-		day := d.Start
-		if len(day) >= 10 {
-			day = day[:10]
+// validateCapabilities checks that OpenCost accepts every configured cost metric and aggregate, with one cheap
+// table query over the last day per cost metric (by service) and per aggregate, and logs the outcome of each.
+// It returns an error naming everything OpenCost rejected (HTTP or body code 400), or the first other failure.
+func (e *exporter) validateCapabilities(ctx context.Context) error {
+	e.queryWindow = "1d"
+	prefix := ""
+	if e.job != "" {
+		prefix = "job " + e.job + ": "
+	}
+	var rejected []string
+	probe := func(what, aggregate, costMetric string) (bool, error) {
+		_, _, err := e.fetchTable(ctx, aggregate, costMetric)
+		if isBadRequest(err) {
+			log.Printf("%scapability check: %s rejected by OpenCost: %v", prefix, what, err)
+			rejected = append(rejected, what)
+			return false, nil
 		}
-		byService := make(map[string]float64, len(d.Items))
-		total := 0.0
-		for _, it := range d.Items {
-			byService[it.Name] = it.Value
-			total += it.Value
+		if err != nil {
+			return false, fmt.Errorf("capability check of %s failed: %w", what, err)
 		}
-		points = append(points, dailyPoint{
-			Day:       day,
-			Total:     total,
-			ByService: byService,
-		})
+		log.Printf("%scapability check: %s ok", prefix, what)
+		return true, nil
 	}
-	return points, nil
+	// Aggregates are probed with a cost metric OpenCost accepted, so a bad cost metric is not blamed on them.
+	accepted := ""
+	for _, costMetric := range e.cfg.CostMetrics {
+		ok, err := probe(fmt.Sprintf("cost metric %q", costMetric), "service", costMetric)
+		if err != nil {
+			return err
+		}
+		if ok && accepted == "" {
+			accepted = costMetric
+		}
+	}
+	if accepted != "" {
+		for _, agg := range e.cfg.Aggregates {
+			if _, err := probe(fmt.Sprintf("aggregate %q", agg), agg, accepted); err != nil {
+				return err
+			}
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("OpenCost rejected %s", strings.Join(rejected, ", "))
+	}
+	return nil
 }
 
-type dailySample struct {
-	desc   *prometheus.Desc
-	labels []string
-	value  float64
-	ts     time.Time
+// jobErr prefixes err with the job name when running several jobs.
+func (e *exporter) jobErr(err error) error {
+	if e.job == "" {
+		return err
+	}
+	return fmt.Errorf("job %s: %w", e.job, err)
 }
 
-type dailyCollector struct {
-	mu sync.Mutex
-
-	dailyAggCostDesc      *prometheus.Desc
-	dailyServiceCostDesc  *prometheus.Desc
-	dailyTotalCostDesc    *prometheus.Desc
-	dailyCategoryCostDesc *prometheus.Desc
+// refreshLoop scrapes in the background until the process exits. Each delay is jittered so replicas started
+// together don't hit OpenCost in lockstep. Ticks keep their cadence while a slow scrape runs; ticks that arrive
+// during it are skipped and counted. While the circuit breaker is open, the delay grows with the consecutive
+// failures (see breakerInterval).
+//
+// The next tick is planned from the delay stored by the last finished scrape (the initial one in main, to begin
+// with) and re-planned from its tick once the running scrape finishes, so the breaker and SCRAPE_ALIGN_TO_NEXT_RUN
+// take effect right after the scrape that changed the delay.
+func (e *exporter) refreshLoop() {
+	lastTick := time.Now()
+	next := lastTick.Add(e.nextDelay())
+	var done chan struct{}
+	for {
+		if done != nil {
+			select {
+			case <-done:
+				done = nil
+				next = lastTick.Add(e.nextDelay())
+				continue
+			case <-e.after(time.Until(next)):
+			}
+		} else {
+			<-e.after(time.Until(next))
+		}
+		now := time.Now()
+		e.tickInterval.Set(now.Sub(lastTick).Seconds())
+		lastTick = now
+		next = now.Add(e.nextDelay())
+		if done != nil {
+			e.scrapeSkipped.Inc()
+			log.Print(e.jobErr(errors.New("previous scrape still running, skipping tick")))
+			continue
+		}
+		scraped := make(chan struct{})
+		done = scraped
+		go func() {
+			defer close(scraped)
+			ctx, cancel := context.WithTimeout(context.Background(), e.cfg.ScrapeTimeout)
+			err := e.scrape(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("scrape failed: %v", e.jobErr(err))
+			}
+		}()
+	}
+}
 
-	samples []dailySample
+// nextDelay is the jittered delay before the next refresh.
+func (e *exporter) nextDelay() time.Duration {
+	return jitteredInterval(time.Duration(e.refreshDelay.Load()), e.cfg.RefreshJitter, rand.Int64N)
 }
 
-func newDailyCollector() *dailyCollector {
-	return &dailyCollector{
-		dailyAggCostDesc: prometheus.NewDesc(
-			"opencost_cloudcost_daily_aggregate_cost",
-			"Cloud cost by aggregate property per day (from /cloudCost/view/graph).",
-			[]string{"aggregate", "name", "day", "window", "cost_metric"},
-			nil,
-		),
-		dailyServiceCostDesc: prometheus.NewDesc(
-			"opencost_cloudcost_daily_service_cost",
-			"Cloud cost by service per day (from /cloudCost/view/graph).",
-			[]string{"service", "day", "window", "cost_metric"},
-			nil,
-		),
-		dailyTotalCostDesc: prometheus.NewDesc(
-			"opencost_cloudcost_daily_total_cost",
-			"Total cloud cost per day (sum of items in /cloudCost/view/graph).",
-			[]string{"day", "window", "cost_metric"},
-			nil,
-		),
-		dailyCategoryCostDesc: prometheus.NewDesc(
-			"opencost_cloudcost_daily_category_cost",
-			"Cloud cost by category (resource type) per day (from /cloudCost/view/graph).",
-			[]string{"category", "day", "window", "cost_metric"},
-			nil,
-		),
-	}
+// indexJob summarizes the configuration of one job on the "/" page.
+type indexJob struct {
+	Job             string `json:"job,omitempty"`
+	OpenCostURL     string `json:"opencost_url"`
+	Window          string `json:"window"`
+	CostMetric      string `json:"cost_metric"`
+	RefreshInterval string `json:"refresh_interval"`
+	HTTPTimeout     string `json:"http_timeout"`
+	ScrapeTimeout   string `json:"scrape_timeout"`
 }
 
-func (d *dailyCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- d.dailyAggCostDesc
-	ch <- d.dailyServiceCostDesc
-	ch <- d.dailyTotalCostDesc
-	ch <- d.dailyCategoryCostDesc
+// indexPage is what "/" serves: the main endpoints and a summary of the configuration.
+type indexPage struct {
+	Endpoints        []string   `json:"endpoints"`
+	Jobs             []indexJob `json:"jobs"`
+	ListenAddr       string     `json:"listen_addr"`
+	HealthListenAddr string     `json:"health_listen_addr"`
 }
 
-func (d *dailyCollector) Collect(ch chan<- prometheus.Metric) {
-	d.mu.Lock()
-	snaps := make([]dailySample, len(d.samples))
-	copy(snaps, d.samples)
-	d.mu.Unlock()
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><title>OpenCost cloud cost exporter</title></head>
+<body>
+<h1>OpenCost cloud cost exporter</h1>
+<ul>
+{{- range .Endpoints}}
+<li><a href="{{.}}">{{.}}</a></li>
+{{- end}}
+</ul>
+<h2>Configuration</h2>
+{{- range .Jobs}}
+{{- if .Job}}
+<h3>Job {{.Job}}</h3>
+{{- end}}
+<ul>
+<li>OPENCOST_URL={{.OpenCostURL}}</li>
+<li>WINDOW={{.Window}}</li>
+<li>COST_METRIC={{.CostMetric}}</li>
+<li>REFRESH_INTERVAL={{.RefreshInterval}}</li>
+<li>HTTP_TIMEOUT={{.HTTPTimeout}} (per request)</li>
+<li>SCRAPE_TIMEOUT={{.ScrapeTimeout}} (whole scrape)</li>
+</ul>
+{{- end}}
+<ul>
+<li>LISTEN_ADDR={{.ListenAddr}}</li>
+<li>HEALTH_LISTEN_ADDR={{.HealthListenAddr}}</li>
+</ul>
+</body>
+</html>
+`))
 
-	for _, s := range snaps {
-		m, err := prometheus.NewConstMetric(s.desc, prometheus.GaugeValue, s.value, s.labels...)
-		if err != nil {
-			log.Printf("daily metric build failed: %v", err)
-			continue
+// serve writes p as JSON or HTML when the client accepts that, and as plain text otherwise (e.g. curl).
+func (p indexPage) serve(w http.ResponseWriter, r *http.Request) {
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/json"):
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		_ = enc.Encode(p)
+	case strings.Contains(accept, "text/html"):
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = indexTemplate.Execute(w, p)
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		var b strings.Builder
+		b.WriteString("opencost cloud cost exporter\n")
+		for _, ep := range p.Endpoints {
+			b.WriteString(ep + "\n")
 		}
-		ch <- prometheus.NewMetricWithTimestamp(s.ts, m)
+		b.WriteString("config:\n")
+		for _, j := range p.Jobs {
+			indent := "  "
+			if j.Job != "" {
+				b.WriteString("  job " + j.Job + ":\n")
+				indent = "    "
+			}
+			b.WriteString(indent + "OPENCOST_URL=" + j.OpenCostURL + "\n")
+			b.WriteString(indent + "WINDOW=" + j.Window + "\n")
+			b.WriteString(indent + "COST_METRIC=" + j.CostMetric + "\n")
+			b.WriteString(indent + "REFRESH_INTERVAL=" + j.RefreshInterval + "\n")
+			b.WriteString(indent + "HTTP_TIMEOUT=" + j.HTTPTimeout + " (per request)\n")
+			b.WriteString(indent + "SCRAPE_TIMEOUT=" + j.ScrapeTimeout + " (whole scrape)\n")
+		}
+		b.WriteString("  LISTEN_ADDR=" + p.ListenAddr + "\n")
+		b.WriteString("  HEALTH_LISTEN_ADDR=" + p.HealthListenAddr + "\n")
+		_, _ = w.Write([]byte(b.String()))
 	}
 }
 
-func (d *dailyCollector) Reset() {
-	d.mu.Lock()
-	d.samples = d.samples[:0]
-	d.mu.Unlock()
+// newMuxes builds the handlers of LISTEN_ADDR and of HEALTH_LISTEN_ADDR; both are the same mux when
+// HEALTH_LISTEN_ADDR is unset. names are the JOBS_FILE job names of exps.
+func newMuxes(cfg config, names []string, exps []*exporter, registry *prometheus.Registry) (mux, healthMux *http.ServeMux) {
+	mux = http.NewServeMux()
+	metrics := promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+	// Multi-target pattern: /metrics?window=7d&cost_metric=netCost (&job=name with JOBS_FILE) scrapes OpenCost on
+	// demand with those parameters and returns only the resulting series.
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(registry, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		window, costMetric := q.Get("window"), q.Get("cost_metric")
+		if window == "" && costMetric == "" {
+			metrics.ServeHTTP(w, r)
+			return
+		}
+		e := exps[0]
+		if i := slices.Index(names, q.Get("job")); i >= 0 {
+			e = exps[i]
+		} else if len(exps) > 1 {
+			http.Error(w, "job is required and must name a JOBS_FILE job", http.StatusBadRequest)
+			return
+		}
+		if window != "" {
+			if _, _, err := validateWindow(window); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), e.cfg.ScrapeTimeout)
+		defer cancel()
+		scoped, err := e.scoped(ctx, window, costMetric)
+		if err != nil {
+			// The scoped registry still reports scrape_success=0, like a failed probe.
+			log.Printf("scoped scrape of window=%s cost_metric=%s failed: %v", window, costMetric, e.jobErr(err))
+		}
+		promhttp.HandlerFor(scoped, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(w, r)
+	})))
+	healthz := func(w http.ResponseWriter, _ *http.Request) {
+		for _, e := range exps {
+			if running, stuck := e.scrapeStuck(); stuck {
+				log.Print(e.jobErr(fmt.Errorf("watchdog: scrape running for %s (SCRAPE_WATCHDOG_TIMEOUT=%s)", running, e.cfg.ScrapeWatchdogTimeout)))
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte("scrape stuck"))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}
+	// With HEALTH_LISTEN_ADDR, health endpoints move to their own server and /metrics stays on LISTEN_ADDR.
+	healthMux = mux
+	if cfg.HealthListenAddr != "" {
+		healthMux = http.NewServeMux()
+	}
+	healthMux.HandleFunc("/healthz", healthz)
+	healthMux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		for _, e := range exps {
+			if !e.cfg.CheckOpenCostHealth {
+				continue
+			}
+			if err := e.checkOpenCost(r.Context()); err != nil {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte("opencost unavailable: " + e.jobErr(err).Error()))
+				return
+			}
+		}
+		healthz(w, r)
+	})
+	if cfg.EnableBackfill {
+		// Ad-hoc backfill, e.g. after an outage: POST /backfill?window=30d&cost_metric=netCost (&job=name with
+		// JOBS_FILE).
+		mux.HandleFunc("/backfill", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			// Each call queries OpenCost, so BACKFILL_TOKEN keeps it from anyone who can reach /metrics.
+			if cfg.BackfillToken != "" &&
+				subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+cfg.BackfillToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			e := exps[0]
+			if i := slices.Index(names, r.URL.Query().Get("job")); i >= 0 {
+				e = exps[i]
+			} else if len(exps) > 1 {
+				http.Error(w, "job is required and must name a JOBS_FILE job", http.StatusBadRequest)
+				return
+			}
+			window := r.URL.Query().Get("window")
+			costMetric := r.URL.Query().Get("cost_metric")
+			if costMetric == "" {
+				costMetric = e.cfg.CostMetric
+			}
+			if window == "" {
+				http.Error(w, "window is required", http.StatusBadRequest)
+				return
+			}
+			if _, _, err := validateWindow(window); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// The regular daily series already use this window label; backfilling it would duplicate series.
+			if window == e.cfg.DailyWindow {
+				http.Error(w, "window is the regularly scraped DAILY_WINDOW", http.StatusBadRequest)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), e.cfg.ScrapeTimeout)
+			defer cancel()
+			n, err := e.backfill(ctx, window, costMetric)
+			if err != nil {
+				log.Printf("backfill of window=%s cost_metric=%s failed: %v", window, costMetric, e.jobErr(err))
+				http.Error(w, "backfill failed: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+			log.Printf("backfilled %d daily samples for window=%s cost_metric=%s", n, window, costMetric)
+			_, _ = fmt.Fprintf(w, "backfilled %d daily samples for window=%s cost_metric=%s\n", n, window, costMetric)
+		})
+	}
+	// GET /config (&job=name with JOBS_FILE) returns every setting with its value and source as JSON.
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		e := exps[0]
+		if i := slices.Index(names, r.URL.Query().Get("job")); i >= 0 {
+			e = exps[i]
+		} else if len(exps) > 1 {
+			http.Error(w, "job is required and must name a JOBS_FILE job", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		_ = enc.Encode(e.cfg.Settings)
+	})
+	if cfg.EnableDebugLastScrape {
+		// GET /debug/lastscrape (&job=name with JOBS_FILE) returns the report of the last finished scrape as JSON.
+		mux.HandleFunc("/debug/lastscrape", func(w http.ResponseWriter, r *http.Request) {
+			e := exps[0]
+			if i := slices.Index(names, r.URL.Query().Get("job")); i >= 0 {
+				e = exps[i]
+			} else if len(exps) > 1 {
+				http.Error(w, "job is required and must name a JOBS_FILE job", http.StatusBadRequest)
+				return
+			}
+			e.lastScrape.mu.Lock()
+			report := e.lastScrape.report
+			e.lastScrape.mu.Unlock()
+			if report == nil {
+				http.Error(w, "no scrape has finished yet", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.SetEscapeHTML(false)
+			_ = enc.Encode(report)
+		})
+	}
+	if cfg.EnablePprof {
+		// Profiling is opt-in: it exposes internals and can be expensive to run.
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	// "/" lists the endpoints and the main settings as JSON, HTML or plain text depending on Accept.
+	index := indexPage{
+		Endpoints:        []string{"/metrics", "/config"},
+		ListenAddr:       cfg.ListenAddr,
+		HealthListenAddr: cfg.HealthListenAddr,
+	}
+	if healthMux == mux {
+		index.Endpoints = append(index.Endpoints, "/healthz", "/readyz")
+	}
+	for _, e := range exps {
+		index.Jobs = append(index.Jobs, indexJob{
+			Job:             e.job,
+			OpenCostURL:     e.cfg.OpenCostURL,
+			Window:          e.cfg.Window,
+			CostMetric:      e.cfg.CostMetric,
+			RefreshInterval: e.cfg.RefreshInterval.String(),
+			HTTPTimeout:     e.cfg.HTTPTimeout.String(),
+			ScrapeTimeout:   e.cfg.ScrapeTimeout.String(),
+		})
+	}
+	// "/{$}" matches only the root itself, so unknown paths (e.g. /debug/pprof/ without ENABLE_PPROF) are 404s.
+	mux.HandleFunc("/{$}", index.serve)
+	return mux, healthMux
 }
 
-func parseDayUTC(day string) (time.Time, error) {
-	// day is expected to be YYYY-MM-DD (derived from OpenCost graph start).
-	return time.ParseInLocation("2006-01-02", day, time.UTC)
+// newRegistry returns the dedicated registry (rather than the global default) shared by all jobs, with the
+// runtime collectors registered explicitly.
+func newRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewBuildInfoCollector(),
+	)
+	return registry
 }
 
-func (d *dailyCollector) add(desc *prometheus.Desc, ts time.Time, value float64, labels ...string) {
-	d.samples = append(d.samples, dailySample{
-		desc:   desc,
-		labels: append([]string(nil), labels...),
-		value:  value,
-		ts:     ts,
-	})
-}
+// initialScrape runs the first scrape of every job before metrics are served. With OUTPUT_FILE it writes the
+// metrics and returns done, since there is nothing left to do. The returned error should stop the process; a
+// failed scrape only returns one with OUTPUT_FILE or FAIL_ON_INITIAL_SCRAPE_ERROR and is logged otherwise.
+func initialScrape(cfg config, exps []*exporter) (done bool, err error) {
+	var scrapeErrs []error
+	for _, e := range exps {
+		ctx, cancel := context.WithTimeout(context.Background(), e.cfg.ScrapeTimeout)
+		if err := e.scrape(ctx); err != nil {
+			scrapeErrs = append(scrapeErrs, e.jobErr(err))
+		}
+		cancel()
+	}
+	scrapeErr := errors.Join(scrapeErrs...)
 
-func (d *dailyCollector) SetAggCost(aggregate, name, day, window, costMetric string, value float64) error {
-	ts, err := parseDayUTC(day)
-	if err != nil {
-		return fmt.Errorf("invalid day %q for daily_aggregate_cost: %w", day, err)
+	// One-shot mode: write the scraped metrics to a file and exit instead of serving HTTP. The registry is
+	// shared, so any exporter writes (and in main, pushes) the metrics of all jobs.
+	if cfg.OutputFile != "" {
+		if err := exps[0].writeMetricsFile(cfg.OutputFile); err != nil {
+			return true, fmt.Errorf("writing %s failed: %w", cfg.OutputFile, err)
+		}
+		if scrapeErr != nil {
+			return true, fmt.Errorf("scrape failed: %w", scrapeErr)
+		}
+		log.Printf("wrote metrics to %s", cfg.OutputFile)
+		return true, nil
 	}
-	d.mu.Lock()
-	d.add(d.dailyAggCostDesc, ts, value, aggregate, name, day, window, costMetric)
-	d.mu.Unlock()
-	return nil
+	if scrapeErr != nil {
+		if cfg.FailOnInitialScrapeError {
+			return false, fmt.Errorf("initial scrape failed: %w", scrapeErr)
+		}
+		// Keep running; metrics will show scrape_success=0.
+		log.Printf("initial scrape failed: %v", scrapeErr)
+	}
+	return false, nil
 }
 
-func (d *dailyCollector) SetServiceCost(service, day, window, costMetric string, value float64) error {
-	ts, err := parseDayUTC(day)
-	if err != nil {
-		return fmt.Errorf("invalid day %q for daily_service_cost: %w", day, err)
+func main() {
+	// With JOBS_FILE, one exporter runs per job; otherwise a single one is configured from the environment.
+	names, cfgs := []string{""}, []config(nil)
+	if path := getenv("JOBS_FILE"); path != "" {
+		names, cfgs = mustJobs(path)
+	} else {
+		cfgs = []config{mustConfig(nil)}
 	}
-	d.mu.Lock()
-	d.add(d.dailyServiceCostDesc, ts, value, service, day, window, costMetric)
-	d.mu.Unlock()
-	return nil
-}
+	// Process-wide settings (processSettings) are the same in every job's config.
+	cfg := cfgs[0]
 
-func (d *dailyCollector) SetTotalCost(day, window, costMetric string, value float64) error {
-	ts, err := parseDayUTC(day)
-	if err != nil {
-		return fmt.Errorf("invalid day %q for daily_total_cost: %w", day, err)
+	registry := newRegistry()
+	exps := make([]*exporter, len(cfgs))
+	for i := range cfgs {
+		exps[i] = newExporter(cfgs[i], registry, names[i])
 	}
-	d.mu.Lock()
-	d.add(d.dailyTotalCostDesc, ts, value, day, window, costMetric)
-	d.mu.Unlock()
-	return nil
-}
 
-func (d *dailyCollector) SetCategoryCost(category, day, window, costMetric string, value float64) error {
-	ts, err := parseDayUTC(day)
-	if err != nil {
-		return fmt.Errorf("invalid day %q for daily_category_cost: %w", day, err)
+	for _, e := range exps {
+		if e.cfg.ScrapeWatchdogTimeout > 0 && e.cfg.ScrapeWatchdogExit {
+			go e.watchdog()
+		}
 	}
-	d.mu.Lock()
-	d.add(d.dailyCategoryCostDesc, ts, value, category, day, window, costMetric)
-	d.mu.Unlock()
-	return nil
-}
 
-func main() {
-	cfg := mustConfig()
-	e := newExporter(cfg)
+	// VALIDATE_CAPABILITIES catches misspelled cost metrics and aggregates before they produce failing scrapes.
+	for _, e := range exps {
+		if !e.cfg.ValidateCapabilities || e.cfg.StatusOnly {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), e.cfg.ScrapeTimeout)
+		err := e.validateCapabilities(ctx)
+		cancel()
+		if err != nil {
+			log.Fatalf("capability check failed: %v", e.jobErr(err))
+		}
+	}
 
-	// Initial scrape before serving metrics.
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.HTTPTimeout)
-	if err := e.scrape(ctx); err != nil {
-		// Keep running; metrics will show scrape_success=0.
-		log.Printf("initial scrape failed: %v", err)
+	done, err := initialScrape(cfg, exps)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if done {
+		return
 	}
-	cancel()
 
-	// Background refresh loop.
-	go func() {
-		t := time.NewTicker(cfg.RefreshInterval)
-		defer t.Stop()
-		for {
-			<-t.C
-			ctx, cancel := context.WithTimeout(context.Background(), cfg.HTTPTimeout)
-			err := e.scrape(ctx)
-			cancel()
-			if err != nil {
-				log.Printf("scrape failed: %v", err)
+	for _, e := range exps {
+		go e.refreshLoop()
+	}
+
+	if cfg.OTelMetricsEnabled {
+		// OTLP push reads the same registry as /metrics; it uses its own client since the OpenCost transport
+		// may be bound to SOCKET_PATH.
+		otlpCli := &http.Client{Timeout: cfg.HTTPTimeout}
+		go func() {
+			t := time.NewTicker(cfg.OTelExportInterval)
+			defer t.Stop()
+			for range t.C {
+				if err := exps[0].pushOTLP(context.Background(), otlpCli); err != nil {
+					log.Printf("otlp push failed: %v", err)
+				}
 			}
-		}
-	}()
+		}()
+	}
 
-	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("ok"))
-	})
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		_, _ = w.Write([]byte("opencost cloud cost exporter\n"))
-		_, _ = w.Write([]byte("/metrics\n"))
-		_, _ = w.Write([]byte("/healthz\n"))
-		_, _ = w.Write([]byte("config:\n"))
-		_, _ = w.Write([]byte("  OPENCOST_URL=" + cfg.OpenCostURL + "\n"))
-		_, _ = w.Write([]byte("  WINDOW=" + cfg.Window + "\n"))
-		_, _ = w.Write([]byte("  COST_METRIC=" + cfg.CostMetric + "\n"))
-		_, _ = w.Write([]byte("  REFRESH_INTERVAL=" + cfg.RefreshInterval.String() + "\n"))
-		_, _ = w.Write([]byte("  HTTP_TIMEOUT=" + cfg.HTTPTimeout.String() + "\n"))
-		_, _ = w.Write([]byte("  LISTEN_ADDR=" + cfg.ListenAddr + "\n"))
-		_ = r
-	})
+	mux, healthMux := newMuxes(cfg, names, exps, registry)
+
+	servers := []*http.Server{{Addr: cfg.ListenAddr, Handler: mux}}
+	if cfg.HealthListenAddr != "" {
+		servers = append(servers, &http.Server{Addr: cfg.HealthListenAddr, Handler: healthMux})
+	}
+
+	listeners := make([]net.Listener, len(servers))
+	for i, srv := range servers {
+		ln, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listeners[i] = ln
+	}
 
-	srv := &http.Server{Addr: cfg.ListenAddr, Handler: mux}
-	log.Printf("listening on %s", cfg.ListenAddr)
-	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := serve(sigCtx, servers, listeners); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// serve runs each server on its listener until ctx is done or a server fails, then shuts all servers down together
+// so the health port doesn't outlive /metrics (or vice versa). It returns the error of the failed server, if any.
+func serve(ctx context.Context, servers []*http.Server, listeners []net.Listener) error {
+	errc := make(chan error, len(servers))
+	for i, srv := range servers {
+		go func() {
+			log.Printf("listening on %s", listeners[i].Addr())
+			if err := srv.Serve(listeners[i]); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errc <- err
+			}
+		}()
+	}
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown of %s failed: %v", srv.Addr, err)
+		}
+	}
+	return err
+}